@@ -0,0 +1,134 @@
+package filelog
+
+import (
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ArchiveDir move rotated segments into pattern, YYYY, MM, DD and HH in
+// pattern are replaced by the segment time, relative pattern is based on the
+// log file directory, e.g. archive/YYYY/MM
+func ArchiveDir(pattern string) OptionWrapper {
+	return func(o *Option) {
+		o.ArchiveDir = pattern
+	}
+}
+
+var archivePlaceholders = []string{"YYYY", "MM", "DD", "HH"}
+
+func resolveArchivePattern(filename, pattern string) string {
+	if pattern == "" || filepath.IsAbs(pattern) {
+		return pattern
+	}
+	return filepath.Join(filepath.Dir(filename), pattern)
+}
+
+// archiveRoot is the deepest directory of the pattern without placeholders.
+func (w *fWriter) archiveRoot() string {
+	root := w.archivePattern
+	for {
+		found := false
+		for _, ph := range archivePlaceholders {
+			if strings.Contains(filepath.Base(root), ph) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return root
+		}
+		root = filepath.Dir(root)
+	}
+}
+
+// archivePath is where segment is moved to.
+func (w *fWriter) archivePath(seg string) string {
+	base := filepath.Base(w.filename)
+	ts, ok := segmentTime(base, filepath.Base(seg))
+	if !ok {
+		ts = time.Now()
+	}
+	dir := strings.NewReplacer(
+		"YYYY", ts.Format("2006"),
+		"MM", ts.Format("01"),
+		"DD", ts.Format("02"),
+		"HH", ts.Format("15"),
+	).Replace(w.archivePattern)
+	return filepath.Join(dir, filepath.Base(seg))
+}
+
+// archive moves a closed segment into the archive tree and returns where the
+// segment is now.
+func (w *fWriter) archive(seg string) string {
+	if w.archivePattern == "" || w.rt == RotateNone || seg == "" {
+		return seg
+	}
+	if _, err := os.Stat(seg); err != nil {
+		return seg
+	}
+	dst := w.archivePath(seg)
+	if err := w.perm.mkdir(filepath.Dir(dst)); err != nil {
+		log.Printf("[filelog] create archive dir %s fail %v\n", filepath.Dir(dst), err)
+		return seg
+	}
+	if err := moveFile(seg, dst); err != nil {
+		log.Printf("[filelog] archive %s fail %v\n", seg, err)
+		return seg
+	}
+	w.perm.chown(dst)
+	return dst
+}
+
+// moveFile renames src to dst, falls back to copy and remove across filesystems.
+func moveFile(src, dst string) error {
+	if err := os.Rename(src, dst); err == nil {
+		return nil
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	fi, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	tmp := dst + ".tmp"
+	out, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, fi.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(out, in); err == nil {
+		err = out.Sync()
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, dst)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Remove(src)
+}
+
+// listArchived lists segments of this writer in the archive tree.
+func (w *fWriter) listArchived(scan *dirScan) []segment {
+	if w.archivePattern == "" {
+		return nil
+	}
+	base := filepath.Base(w.filename)
+	var segs []segment
+	for _, f := range scan.walk(w.archiveRoot()) {
+		if ts, ok := segmentTime(base, f.fi.Name()); ok {
+			segs = append(segs, segment{path: f.path, size: f.fi.Size(), ts: ts})
+		}
+	}
+	return segs
+}
@@ -0,0 +1,253 @@
+package filelog
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// AuditMode frame every record with a sequence number and a hash chained to
+// the previous record, segments start with a header record carrying the
+// hash of the last record of the previous segment. Use VerifyAudit to check
+// the chain.
+//
+// A framed record looks like
+//
+//	#<seq> <payload length> <hex sha256(prev hash, seq, payload)>\n<payload>\n
+func AuditMode() OptionWrapper {
+	return func(o *Option) {
+		o.Audit = true
+	}
+}
+
+const auditHeaderPrefix = "AUDIT-SEGMENT "
+
+type auditState struct {
+	seq    uint64
+	hash   [sha256.Size]byte
+	loaded bool
+	// chain position of the last record written to disk
+	durableSeq  uint64
+	durableHash [sha256.Size]byte
+}
+
+func auditHash(prev [sha256.Size]byte, seq uint64, payload []byte) [sha256.Size]byte {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], seq)
+	h := sha256.New()
+	h.Write(prev[:])
+	h.Write(b[:])
+	h.Write(payload)
+	var sum [sha256.Size]byte
+	copy(sum[:], h.Sum(nil))
+	return sum
+}
+
+// appendFrame frames payload as the next record of the chain.
+func (st *auditState) appendFrame(buf, payload []byte) []byte {
+	st.seq++
+	st.hash = auditHash(st.hash, st.seq, payload)
+	buf = append(buf, fmt.Sprintf("#%d %d %s\n", st.seq, len(payload), hex.EncodeToString(st.hash[:]))...)
+	buf = append(buf, payload...)
+	return append(buf, '\n')
+}
+
+// commit marks every framed record as written to disk.
+func (st *auditState) commit() {
+	st.durableSeq, st.durableHash = st.seq, st.hash
+}
+
+// rewind drops the chain of records not written to disk.
+func (st *auditState) rewind() {
+	st.seq, st.hash = st.durableSeq, st.durableHash
+}
+
+// reframe appends the records framed in buf to out as the next records of the
+// chain, stale segment headers are dropped.
+func (st *auditState) reframe(out, buf []byte) []byte {
+	ReadAuditRecords(bytes.NewReader(buf), func(rec AuditRecord) error {
+		if !rec.Header {
+			out = st.appendFrame(out, rec.Payload)
+		}
+		return nil
+	})
+	return out
+}
+
+// maxAuditFrameSize bounds the record size accepted by ReadAuditRecords
+const maxAuditFrameSize = 64 * M
+
+// AuditRecord is a record read from an audit log
+type AuditRecord struct {
+	Seq     uint64
+	Hash    [sha256.Size]byte
+	Payload []byte
+	Header  bool
+}
+
+// ReadAuditRecords calls fn with every framed record read from r
+func ReadAuditRecords(r io.Reader, fn func(AuditRecord) error) error {
+	br := bufio.NewReader(r)
+	for {
+		line, err := br.ReadString('\n')
+		if err == io.EOF && line == "" {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("truncated frame: %v", err)
+		}
+		var rec AuditRecord
+		var size int
+		var sum string
+		if _, err := fmt.Sscanf(line, "#%d %d %s\n", &rec.Seq, &size, &sum); err != nil {
+			return fmt.Errorf("bad frame %q", strings.TrimSpace(line))
+		}
+		if b, err := hex.DecodeString(sum); err != nil || len(b) != sha256.Size {
+			return fmt.Errorf("bad hash %q", sum)
+		} else {
+			copy(rec.Hash[:], b)
+		}
+		if size < 0 || size > maxAuditFrameSize {
+			return fmt.Errorf("bad record size %d", size)
+		}
+		rec.Payload = make([]byte, size+1)
+		if _, err := io.ReadFull(br, rec.Payload); err != nil || rec.Payload[size] != '\n' {
+			return fmt.Errorf("truncated record %d", rec.Seq)
+		}
+		rec.Payload = rec.Payload[:size]
+		rec.Header = bytes.HasPrefix(rec.Payload, []byte(auditHeaderPrefix))
+		if err := fn(rec); err != nil {
+			return err
+		}
+	}
+}
+
+// AuditError is returned by VerifyAudit when the chain is broken
+type AuditError struct {
+	File   string
+	Seq    uint64
+	Reason string
+}
+
+func (e *AuditError) Error() string {
+	return fmt.Sprintf("audit %s seq %d: %s", e.File, e.Seq, e.Reason)
+}
+
+// VerifyAudit verifies audit log segments given in writing order, it detects
+// modified, deleted or reordered records and deleted or reordered segments
+func VerifyAudit(files ...string) error {
+	var prev [sha256.Size]byte
+	var seq uint64
+	for i, file := range files {
+		fd, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		first := true
+		err = ReadAuditRecords(fd, func(rec AuditRecord) error {
+			if first {
+				first = false
+				if !rec.Header {
+					return &AuditError{File: file, Seq: rec.Seq, Reason: "missing segment header"}
+				}
+				fields := parseAuditHeader(rec.Payload)
+				b, err := hex.DecodeString(fields["prevhash"])
+				if err != nil || len(b) != sha256.Size {
+					return &AuditError{File: file, Seq: rec.Seq, Reason: "bad segment header"}
+				}
+				var headerPrev [sha256.Size]byte
+				copy(headerPrev[:], b)
+				if i == 0 {
+					prev, seq = headerPrev, rec.Seq-1
+				} else if headerPrev != prev {
+					return &AuditError{File: file, Seq: rec.Seq, Reason: "segment does not follow previous segment"}
+				}
+			}
+			if rec.Seq != seq+1 {
+				return &AuditError{File: file, Seq: rec.Seq, Reason: fmt.Sprintf("expect seq %d, records deleted or reordered", seq+1)}
+			}
+			if auditHash(prev, rec.Seq, rec.Payload) != rec.Hash {
+				return &AuditError{File: file, Seq: rec.Seq, Reason: "hash mismatch, record modified"}
+			}
+			prev, seq = rec.Hash, rec.Seq
+			return nil
+		})
+		fd.Close()
+		if err != nil {
+			if _, ok := err.(*AuditError); !ok {
+				err = &AuditError{File: file, Seq: seq + 1, Reason: err.Error()}
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+func parseAuditHeader(payload []byte) map[string]string {
+	fields := make(map[string]string)
+	for _, kv := range strings.Fields(strings.TrimPrefix(string(payload), auditHeaderPrefix)) {
+		if i := strings.IndexByte(kv, '='); i > 0 {
+			fields[kv[:i]] = kv[i+1:]
+		}
+	}
+	return fields
+}
+
+// loadAudit resumes the chain from the current segment, or from the newest
+// previous segment when the current one is empty.
+func (w *fWriter) loadAudit() {
+	if w.audit == nil || w.audit.loaded {
+		return
+	}
+	w.audit.loaded = true
+	candidates := []string{w.realFilename}
+	for _, seg := range w.listSegments() {
+		if !seg.current && !isCompressed(seg.path) {
+			candidates = append(candidates, seg.path)
+		}
+	}
+	for _, file := range candidates {
+		fd, err := w.openPlain(file)
+		if err != nil {
+			continue
+		}
+		var records int64
+		ReadAuditRecords(fd, func(rec AuditRecord) error {
+			w.audit.seq, w.audit.hash = rec.Seq, rec.Hash
+			records++
+			return nil
+		})
+		fd.Close()
+		if records == 0 {
+			continue
+		}
+		if file == w.realFilename {
+			w.stats.records = records
+		} else if w.prevSegment == "" {
+			w.prevSegment = file
+		}
+		w.audit.commit()
+		return
+	}
+}
+
+// appendAuditHeader starts the chain of a new segment.
+func (w *fWriter) appendAuditHeader(buf []byte) []byte {
+	prev := "-"
+	if w.prevSegment != "" {
+		prev = filepath.Base(w.prevSegment)
+	}
+	header := auditHeaderPrefix + "name=" + filepath.Base(w.realFilename) +
+		" prev=" + prev +
+		" prevhash=" + hex.EncodeToString(w.audit.hash[:]) +
+		" seq=" + strconv.FormatUint(w.audit.seq+1, 10)
+	return w.audit.appendFrame(buf, []byte(header))
+}
@@ -0,0 +1,85 @@
+package filelog
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeAuditSegments writes three chained segments of two records each.
+func writeAuditSegments(t *testing.T) []string {
+	t.Helper()
+	dir := t.TempDir()
+	path := filepath.Join(dir, "app.log")
+	var segs []string
+	for i, day := range []string{"2020-01-01", "2020-01-02", "2020-01-03"} {
+		n := byte('a' + 2*i)
+		writeRecords(t, path, []string{"rec-" + string(n), "rec-" + string(n+1)}, AuditMode())
+		seg := path + "." + day
+		if err := os.Rename(path, seg); err != nil {
+			t.Fatal(err)
+		}
+		segs = append(segs, seg)
+	}
+	return segs
+}
+
+// auditFrames splits an audit segment of single line records into frames.
+func auditFrames(t *testing.T, path string) [][]byte {
+	t.Helper()
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := bytes.SplitAfter(data, []byte("\n"))
+	var frames [][]byte
+	for i := 0; i+1 < len(lines); i += 2 {
+		frames = append(frames, append(lines[i], lines[i+1]...))
+	}
+	return frames
+}
+
+func TestVerifyAudit(t *testing.T) {
+	segs := writeAuditSegments(t)
+	if err := VerifyAudit(segs...); err != nil {
+		t.Fatal(err)
+	}
+	// header and two records
+	if frames := auditFrames(t, segs[1]); len(frames) != 3 {
+		t.Fatalf("expect 3 frames, got %d", len(frames))
+	}
+	tamper := map[string]func(frames [][]byte) [][]byte{
+		"modified": func(frames [][]byte) [][]byte {
+			frames[1] = bytes.Replace(frames[1], []byte("rec-c"), []byte("rec-x"), 1)
+			return frames
+		},
+		"deleted": func(frames [][]byte) [][]byte {
+			return append(frames[:1], frames[2:]...)
+		},
+		"reordered": func(frames [][]byte) [][]byte {
+			frames[1], frames[2] = frames[2], frames[1]
+			return frames
+		},
+	}
+	for name, fn := range tamper {
+		t.Run(name, func(t *testing.T) {
+			segs := writeAuditSegments(t)
+			frames := fn(auditFrames(t, segs[1]))
+			if err := ioutil.WriteFile(segs[1], bytes.Join(frames, nil), 0644); err != nil {
+				t.Fatal(err)
+			}
+			err := VerifyAudit(segs...)
+			if aerr, ok := err.(*AuditError); !ok || aerr.File != segs[1] {
+				t.Fatalf("expect AuditError in %s, got %v", segs[1], err)
+			}
+		})
+	}
+	t.Run("dropped segment", func(t *testing.T) {
+		err := VerifyAudit(segs[0], segs[2])
+		if aerr, ok := err.(*AuditError); !ok || aerr.File != segs[2] {
+			t.Fatalf("expect AuditError in %s, got %v", segs[2], err)
+		}
+	})
+}
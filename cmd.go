@@ -0,0 +1,150 @@
+package filelog
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"sync"
+)
+
+// CmdOption options of AttachCmd
+type CmdOption struct {
+	Name        string
+	MaxLineSize int
+}
+
+type CmdOptionWrapper func(*CmdOption)
+
+// CmdName set the process name used in line prefix, default is the base name of cmd.Path
+func CmdName(name string) CmdOptionWrapper {
+	return func(o *CmdOption) {
+		o.Name = name
+	}
+}
+
+// CmdMaxLineSize lines longer than size would be split into several records
+func CmdMaxLineSize(size int) CmdOptionWrapper {
+	return func(o *CmdOption) {
+		o.MaxLineSize = size
+	}
+}
+
+// AttachedCmd is an exec.Cmd whose stdout/stderr are captured by a FileLogWriter
+type AttachedCmd struct {
+	*exec.Cmd
+	stdout *lineWriter
+	stderr *lineWriter
+}
+
+// AttachCmd wire cmd.Stdout and cmd.Stderr to w, every line is prefixed with
+// name[pid] stream and written as one record, so lines from different children
+// never interleave. Use Run or Wait of the returned AttachedCmd so that the
+// last unterminated line is flushed when process exits.
+func AttachCmd(cmd *exec.Cmd, w FileLogWriter, wrappers ...CmdOptionWrapper) (*AttachedCmd, error) {
+	if cmd.Stdout != nil {
+		return nil, fmt.Errorf("filelog: Stdout already set")
+	}
+	if cmd.Stderr != nil {
+		return nil, fmt.Errorf("filelog: Stderr already set")
+	}
+	opt := &CmdOption{
+		Name:        filepath.Base(cmd.Path),
+		MaxLineSize: 64 * K,
+	}
+	for _, fn := range wrappers {
+		fn(opt)
+	}
+	if opt.MaxLineSize <= 0 {
+		return nil, fmt.Errorf("filelog: max line size %d <= 0", opt.MaxLineSize)
+	}
+	ac := &AttachedCmd{Cmd: cmd}
+	ac.stdout = &lineWriter{cmd: cmd, name: opt.Name, stream: "stdout", w: w, max: opt.MaxLineSize}
+	ac.stderr = &lineWriter{cmd: cmd, name: opt.Name, stream: "stderr", w: w, max: opt.MaxLineSize}
+	cmd.Stdout = ac.stdout
+	cmd.Stderr = ac.stderr
+	return ac, nil
+}
+
+// Run starts the process and waits for it to exit
+func (c *AttachedCmd) Run() error {
+	if err := c.Cmd.Start(); err != nil {
+		return err
+	}
+	return c.Wait()
+}
+
+// Wait waits for the process to exit and flush pending partial lines
+func (c *AttachedCmd) Wait() error {
+	err := c.Cmd.Wait()
+	c.stdout.flush()
+	c.stderr.flush()
+	return err
+}
+
+type lineWriter struct {
+	mu     sync.Mutex
+	cmd    *exec.Cmd
+	name   string
+	stream string
+	w      FileLogWriter
+	max    int
+	buf    []byte
+}
+
+func (lw *lineWriter) Write(p []byte) (int, error) {
+	lw.mu.Lock()
+	defer lw.mu.Unlock()
+	n := len(p)
+	for len(p) > 0 {
+		i := bytes.IndexByte(p, '\n')
+		if i < 0 {
+			lw.buf = append(lw.buf, p...)
+			for len(lw.buf) > lw.max {
+				lw.emit(lw.buf[:lw.max])
+				lw.buf = lw.buf[lw.max:]
+			}
+			break
+		}
+		if len(lw.buf) > 0 {
+			lw.buf = append(lw.buf, p[:i]...)
+			lw.emitLine(lw.buf)
+			lw.buf = lw.buf[:0]
+		} else {
+			lw.emitLine(p[:i])
+		}
+		p = p[i+1:]
+	}
+	return n, nil
+}
+
+func (lw *lineWriter) flush() {
+	lw.mu.Lock()
+	defer lw.mu.Unlock()
+	if len(lw.buf) > 0 {
+		lw.emitLine(lw.buf)
+		lw.buf = lw.buf[:0]
+	}
+}
+
+// emitLine emits a complete line, split into records of at most max bytes
+func (lw *lineWriter) emitLine(line []byte) {
+	for len(line) > lw.max {
+		lw.emit(line[:lw.max])
+		line = line[lw.max:]
+	}
+	lw.emit(line)
+}
+
+func (lw *lineWriter) emit(line []byte) {
+	pid := 0
+	if lw.cmd.Process != nil {
+		pid = lw.cmd.Process.Pid
+	}
+	prefix := fmt.Sprintf("%s[%d] %s: ", lw.name, pid, lw.stream)
+	record := make([]byte, 0, len(prefix)+len(line)+1)
+	record = append(record, prefix...)
+	record = append(record, line...)
+	record = append(record, '\n')
+	lw.w.Write(record)
+}
@@ -0,0 +1,134 @@
+// Command filelog inspects files written by github.com/qjpcpu/filelog.
+//
+//	filelog verify app.log.2006-01-02 app.log.2006-01-03
+//	FILELOG_KEY=<hex key> filelog decrypt -key-id k1 app.log.2006-01-02
+//	filelog segments -manifest app.log.manifest -from 2006-01-02T10:00:00Z -to 2006-01-02T10:30:00Z
+//	filelog ring app.ring
+package main
+
+import (
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/qjpcpu/filelog"
+)
+
+var commands = map[string]func(args []string) error{
+	"verify":   verify,
+	"segments": segments,
+	"decrypt":  decrypt,
+	"ring":     ring,
+}
+
+func main() {
+	if len(os.Args) < 2 || commands[os.Args[1]] == nil {
+		usage()
+		os.Exit(2)
+	}
+	if err := commands[os.Args[1]](os.Args[2:]); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: filelog <command> [arguments]")
+	fmt.Fprintln(os.Stderr, "commands:")
+	fmt.Fprintln(os.Stderr, "  verify FILE...     verify hash chain of audit log segments in writing order")
+	fmt.Fprintln(os.Stderr, "  segments           list segments covering a time range from manifest")
+	fmt.Fprintln(os.Stderr, "  decrypt FILE...    write plain text of encrypted segments to stdout")
+	fmt.Fprintln(os.Stderr, "  ring FILE...       write records of ring files to stdout, oldest first")
+}
+
+func verify(args []string) error {
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		return fmt.Errorf("no file to verify")
+	}
+	if err := filelog.VerifyAudit(fs.Args()...); err != nil {
+		return err
+	}
+	fmt.Printf("%d segments ok\n", fs.NArg())
+	return nil
+}
+
+func segments(args []string) error {
+	fs := flag.NewFlagSet("segments", flag.ExitOnError)
+	manifest := fs.String("manifest", "", "manifest file")
+	from := fs.String("from", "", "range start in RFC3339, default no limit")
+	to := fs.String("to", "", "range end in RFC3339, default no limit")
+	fs.Parse(args)
+	if *manifest == "" {
+		return fmt.Errorf("-manifest is required")
+	}
+	start, end := time.Time{}, time.Unix(1<<62, 0)
+	var err error
+	if *from != "" {
+		if start, err = time.Parse(time.RFC3339, *from); err != nil {
+			return err
+		}
+	}
+	if *to != "" {
+		if end, err = time.Parse(time.RFC3339, *to); err != nil {
+			return err
+		}
+	}
+	infos, err := filelog.ReadManifest(*manifest)
+	if err != nil {
+		return err
+	}
+	for _, si := range filelog.SegmentsCovering(infos, start, end) {
+		fmt.Printf("%s\t%s\t%s\t%d\t%d\n", si.Path, si.FirstRecord.Format(time.RFC3339), si.LastRecord.Format(time.RFC3339), si.Records, si.Size)
+	}
+	return nil
+}
+
+func decrypt(args []string) error {
+	fs := flag.NewFlagSet("decrypt", flag.ExitOnError)
+	keyID := fs.String("key-id", "", "key id")
+	key := fs.String("key", os.Getenv("FILELOG_KEY"), "hex encoded key, default $FILELOG_KEY")
+	fs.Parse(args)
+	secret, err := hex.DecodeString(*key)
+	if err != nil || len(secret) == 0 {
+		return fmt.Errorf("bad key: hex encoded key is required")
+	}
+	kp := filelog.StaticKey{ID: *keyID, Secret: secret}
+	for _, file := range fs.Args() {
+		fd, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		r, err := filelog.NewDecryptReader(fd, kp)
+		if err == nil {
+			_, err = io.Copy(os.Stdout, r)
+		}
+		fd.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %v", file, err)
+		}
+	}
+	return nil
+}
+
+func ring(args []string) error {
+	fs := flag.NewFlagSet("ring", flag.ExitOnError)
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		return fmt.Errorf("no ring file to read")
+	}
+	for _, file := range fs.Args() {
+		err := filelog.ReadRingRecords(file, func(record []byte) error {
+			_, err := os.Stdout.Write(record)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
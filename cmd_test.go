@@ -0,0 +1,38 @@
+package filelog
+
+import (
+	"os/exec"
+	"reflect"
+	"testing"
+)
+
+type recordsWriter struct {
+	FileLogWriter
+	records []string
+}
+
+func (w *recordsWriter) Write(p []byte) (int, error) {
+	w.records = append(w.records, string(p))
+	return len(p), nil
+}
+
+func TestCmdSplitsLongLines(t *testing.T) {
+	out := &recordsWriter{}
+	lw := &lineWriter{cmd: &exec.Cmd{}, name: "job", stream: "stdout", w: out, max: 4}
+	lw.Write([]byte("abcdefghij\nxy\n"))
+	// a partial line of exactly max bytes is emitted once its newline arrives
+	lw.Write([]byte("klmn"))
+	lw.Write([]byte("\nop"))
+	lw.flush()
+	expect := []string{
+		"job[0] stdout: abcd\n",
+		"job[0] stdout: efgh\n",
+		"job[0] stdout: ij\n",
+		"job[0] stdout: xy\n",
+		"job[0] stdout: klmn\n",
+		"job[0] stdout: op\n",
+	}
+	if !reflect.DeepEqual(out.records, expect) {
+		t.Fatalf("got %q, expect %q", out.records, expect)
+	}
+}
@@ -0,0 +1,489 @@
+package filelog
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/bits"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ByteSize is a size in bytes, text form accepts units like 512MB, 1.5G or 64KiB
+type ByteSize int64
+
+var byteUnits = []struct {
+	suffix string
+	size   float64
+}{
+	{"kib", K}, {"mib", M}, {"gib", G}, {"tib", 1 << 40},
+	{"kb", K}, {"mb", M}, {"gb", G}, {"tb", 1 << 40},
+	{"k", K}, {"m", M}, {"g", G}, {"t", 1 << 40},
+	{"b", 1},
+}
+
+// ParseByteSize parse sizes like 512MB, 1.5G, 64KiB or 1024
+func ParseByteSize(s string) (ByteSize, error) {
+	str := strings.ToLower(strings.TrimSpace(s))
+	mul := 1.0
+	for _, u := range byteUnits {
+		if strings.HasSuffix(str, u.suffix) {
+			str, mul = strings.TrimSpace(strings.TrimSuffix(str, u.suffix)), u.size
+			break
+		}
+	}
+	f, err := strconv.ParseFloat(str, 64)
+	if err != nil || f < 0 {
+		return 0, fmt.Errorf("invalid size %q, want e.g. 512MB, 1.5G or 1024", s)
+	}
+	return ByteSize(f * mul), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler
+func (b *ByteSize) UnmarshalText(text []byte) error {
+	v, err := ParseByteSize(string(text))
+	if err == nil {
+		*b = v
+	}
+	return err
+}
+
+// UnmarshalJSON accepts both numbers and strings
+func (b *ByteSize) UnmarshalJSON(data []byte) error {
+	if s, err := strconv.Unquote(string(data)); err == nil {
+		return b.UnmarshalText([]byte(s))
+	}
+	return b.UnmarshalText(data)
+}
+
+// Duration is a time.Duration, text form also accepts days like 30d
+type Duration time.Duration
+
+// ParseDuration parse durations like 10ms, 1h30m or 30d
+func ParseDuration(s string) (Duration, error) {
+	str := strings.TrimSpace(s)
+	if strings.HasSuffix(str, "d") {
+		days, err := strconv.ParseFloat(strings.TrimSuffix(str, "d"), 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration %q, want e.g. 10ms, 1h30m or 30d", s)
+		}
+		return Duration(days * float64(24*time.Hour)), nil
+	}
+	d, err := time.ParseDuration(str)
+	if err != nil {
+		return 0, fmt.Errorf("invalid duration %q, want e.g. 10ms, 1h30m or 30d", s)
+	}
+	return Duration(d), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler
+func (d *Duration) UnmarshalText(text []byte) error {
+	v, err := ParseDuration(string(text))
+	if err == nil {
+		*d = v
+	}
+	return err
+}
+
+// UnmarshalJSON accepts strings, numbers are nanoseconds
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	if s, err := strconv.Unquote(string(data)); err == nil {
+		return d.UnmarshalText([]byte(s))
+	}
+	n, err := strconv.ParseInt(string(data), 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid duration %s, want e.g. \"10ms\" or \"30d\"", data)
+	}
+	*d = Duration(n)
+	return nil
+}
+
+// Config declarative writer configuration, zero values keep defaults of NewWriter.
+// Load it with LoadConfigFile or LoadConfigFromEnv. There is no YAML loader,
+// the yaml tags serve YAML libraries of the caller, ByteSize and Duration
+// implement encoding.TextUnmarshaler for that.
+type Config struct {
+	Filename         string   `json:"filename" yaml:"filename" env:"FILENAME"`
+	Rotate           string   `json:"rotate" yaml:"rotate" env:"ROTATE"`
+	CreateShortcut   bool     `json:"create_shortcut" yaml:"create_shortcut" env:"CREATE_SHORTCUT"`
+	BufferSize       uint64   `json:"buffer_size" yaml:"buffer_size" env:"BUFFER_SIZE"`
+	FlushInterval    Duration `json:"flush_interval" yaml:"flush_interval" env:"FLUSH_INTERVAL"`
+	Keep             int      `json:"keep" yaml:"keep" env:"KEEP"`
+	KeepFor          Duration `json:"keep_for" yaml:"keep_for" env:"KEEP_FOR"`
+	KeepMaxSize      ByteSize `json:"keep_max_size" yaml:"keep_max_size" env:"KEEP_MAX_SIZE"`
+	CleanupInterval  Duration `json:"cleanup_interval" yaml:"cleanup_interval" env:"CLEANUP_INTERVAL"`
+	DisableWatch     bool     `json:"disable_watch" yaml:"disable_watch" env:"DISABLE_WATCH"`
+	EnsureNewline    bool     `json:"ensure_newline" yaml:"ensure_newline" env:"ENSURE_NEWLINE"`
+	MaxRecordSize    ByteSize `json:"max_record_size" yaml:"max_record_size" env:"MAX_RECORD_SIZE"`
+	BatchSize        int      `json:"batch_size" yaml:"batch_size" env:"BATCH_SIZE"`
+	LowLatency       bool     `json:"low_latency" yaml:"low_latency" env:"LOW_LATENCY"`
+	DropOnOverflow   bool     `json:"drop_on_overflow" yaml:"drop_on_overflow" env:"DROP_ON_OVERFLOW"`
+	FallbackBuffer   ByteSize `json:"fallback_buffer" yaml:"fallback_buffer" env:"FALLBACK_BUFFER"`
+	FallbackDir      string   `json:"fallback_dir" yaml:"fallback_dir" env:"FALLBACK_DIR"`
+	ProbeInterval    Duration `json:"probe_interval" yaml:"probe_interval" env:"PROBE_INTERVAL"`
+	MinFreeSpace     ByteSize `json:"min_free_space" yaml:"min_free_space" env:"MIN_FREE_SPACE"`
+	MinFreePercent   float64  `json:"min_free_percent" yaml:"min_free_percent" env:"MIN_FREE_PERCENT"`
+	ArchiveDir       string   `json:"archive_dir" yaml:"archive_dir" env:"ARCHIVE_DIR"`
+	Manifest         bool     `json:"manifest" yaml:"manifest" env:"MANIFEST"`
+	ManifestPath     string   `json:"manifest_path" yaml:"manifest_path" env:"MANIFEST_PATH"`
+	Audit            bool     `json:"audit" yaml:"audit" env:"AUDIT"`
+	DedupWindow      Duration `json:"dedup_window" yaml:"dedup_window" env:"DEDUP_WINDOW"`
+	RateLimit        float64  `json:"rate_limit" yaml:"rate_limit" env:"RATE_LIMIT"`
+	RateBurst        int      `json:"rate_burst" yaml:"rate_burst" env:"RATE_BURST"`
+	SampleFirst      int      `json:"sample_first" yaml:"sample_first" env:"SAMPLE_FIRST"`
+	SampleThereafter int      `json:"sample_thereafter" yaml:"sample_thereafter" env:"SAMPLE_THEREAFTER"`
+	FileMode         string   `json:"file_mode" yaml:"file_mode" env:"FILE_MODE"`
+	DirMode          string   `json:"dir_mode" yaml:"dir_mode" env:"DIR_MODE"`
+	Owner            string   `json:"owner" yaml:"owner" env:"OWNER"`
+	MkdirAll         bool     `json:"mkdir_all" yaml:"mkdir_all" env:"MKDIR_ALL"`
+	ShortcutMode     string   `json:"shortcut_mode" yaml:"shortcut_mode" env:"SHORTCUT_MODE"`
+	ShortcutName     string   `json:"shortcut_name" yaml:"shortcut_name" env:"SHORTCUT_NAME"`
+	RingSize         ByteSize `json:"ring_size" yaml:"ring_size" env:"RING_SIZE"`
+}
+
+// ConfigError is a problem of one Config field
+type ConfigError struct {
+	Field  string
+	Value  interface{}
+	Reason string
+}
+
+func (e ConfigError) Error() string {
+	return fmt.Sprintf("%s=%v: %s", e.Field, e.Value, e.Reason)
+}
+
+// ConfigErrors all problems found in a Config
+type ConfigErrors []ConfigError
+
+func (errs ConfigErrors) Error() string {
+	lines := make([]string, 0, len(errs))
+	for _, e := range errs {
+		lines = append(lines, e.Error())
+	}
+	return "invalid filelog config:\n  " + strings.Join(lines, "\n  ")
+}
+
+var rotatePeriods = map[RotateType]time.Duration{
+	RotateMinute: time.Minute,
+	RotateHourly: time.Hour,
+	RotateDaily:  24 * time.Hour,
+	RotateWeekly: 7 * 24 * time.Hour,
+}
+
+// Validate checks every field and reports all problems at once
+func (c Config) Validate() error {
+	var errs ConfigErrors
+	add := func(field string, value interface{}, format string, args ...interface{}) {
+		errs = append(errs, ConfigError{Field: field, Value: value, Reason: fmt.Sprintf(format, args...)})
+	}
+	if c.Filename == "" {
+		add("filename", `""`, "log file path is required")
+	}
+	rt := RotateNone
+	if c.Rotate != "" {
+		var err error
+		if rt, err = ParseRotateType(c.Rotate); err != nil {
+			add("rotate", c.Rotate, "want one of daily, hourly, minute, weekly, none")
+		}
+	}
+	if c.BufferSize != 0 && !is2n(c.BufferSize) {
+		add("buffer_size", c.BufferSize, "must be a power of 2, e.g. %d", 1<<bits.Len64(c.BufferSize-1))
+	}
+	if c.FlushInterval < 0 {
+		add("flush_interval", time.Duration(c.FlushInterval), "must be positive")
+	}
+	if c.Keep < 0 {
+		add("keep", c.Keep, "must not be negative")
+	}
+	if c.KeepFor != 0 {
+		if c.Keep != 0 {
+			add("keep_for", time.Duration(c.KeepFor), "set either keep or keep_for")
+		}
+		if rotatePeriods[rt] == 0 {
+			add("keep_for", time.Duration(c.KeepFor), "requires rotate daily, hourly, minute or weekly")
+		} else if time.Duration(c.KeepFor) < rotatePeriods[rt] {
+			add("keep_for", time.Duration(c.KeepFor), "shorter than the rotate period %v", rotatePeriods[rt])
+		}
+	}
+	if c.KeepMaxSize < 0 {
+		add("keep_max_size", c.KeepMaxSize, "must not be negative")
+	}
+	if c.CleanupInterval < 0 {
+		add("cleanup_interval", time.Duration(c.CleanupInterval), "must be positive")
+	}
+	if c.MaxRecordSize < 0 || (c.MaxRecordSize > 0 && c.MaxRecordSize <= ByteSize(len(truncatedMarker)+1)) {
+		add("max_record_size", c.MaxRecordSize, "must be larger than %d bytes", len(truncatedMarker)+1)
+	}
+	if c.BatchSize < 0 {
+		add("batch_size", c.BatchSize, "must not be negative")
+	}
+	if c.FallbackBuffer < 0 {
+		add("fallback_buffer", c.FallbackBuffer, "must not be negative")
+	}
+	if c.ProbeInterval < 0 {
+		add("probe_interval", time.Duration(c.ProbeInterval), "must be positive")
+	}
+	if c.MinFreeSpace < 0 {
+		add("min_free_space", c.MinFreeSpace, "must not be negative")
+	}
+	if c.MinFreePercent < 0 || c.MinFreePercent >= 100 {
+		add("min_free_percent", c.MinFreePercent, "must be in [0, 100)")
+	}
+	if c.DedupWindow < 0 {
+		add("dedup_window", time.Duration(c.DedupWindow), "must be positive")
+	}
+	if c.RateLimit < 0 {
+		add("rate_limit", c.RateLimit, "must not be negative")
+	}
+	if c.RateLimit > 0 && c.RateBurst < 1 {
+		add("rate_burst", c.RateBurst, "must be at least 1 when rate_limit is set")
+	}
+	if c.SampleFirst < 0 || c.SampleThereafter < 0 {
+		add("sample_first", c.SampleFirst, "sample_first and sample_thereafter must not be negative")
+	}
+	if _, err := parseMode(c.FileMode); err != nil {
+		add("file_mode", c.FileMode, "want octal permission like 0640")
+	}
+	if _, err := parseMode(c.DirMode); err != nil {
+		add("dir_mode", c.DirMode, "want octal permission like 0750")
+	}
+	if _, _, err := parseOwner(c.Owner); err != nil {
+		add("owner", c.Owner, "want numeric uid:gid like 1000:1000, -1 keeps current")
+	}
+	if c.ShortcutMode != "" {
+		if _, err := ParseShortcutMode(c.ShortcutMode); err != nil {
+			add("shortcut_mode", c.ShortcutMode, "want one of symlink, absolute, hardlink")
+		}
+	}
+	if c.RingSize < 0 {
+		add("ring_size", c.RingSize, "must not be negative")
+	}
+	if c.RingSize > 0 {
+		if rt != RotateNone {
+			add("ring_size", c.RingSize, "ring file doesn't rotate, set rotate to none")
+		}
+		if c.Audit || c.Manifest {
+			add("ring_size", c.RingSize, "ring file doesn't support audit or manifest")
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+func parseMode(s string) (os.FileMode, error) {
+	if s == "" {
+		return 0, nil
+	}
+	m, err := strconv.ParseUint(s, 8, 32)
+	if err != nil || m > 0777 {
+		return 0, fmt.Errorf("invalid mode %q", s)
+	}
+	return os.FileMode(m), nil
+}
+
+func parseOwner(s string) (uid, gid int, err error) {
+	if s == "" {
+		return -1, -1, nil
+	}
+	parts := strings.SplitN(s, ":", 2)
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("invalid owner %q", s)
+	}
+	if uid, err = strconv.Atoi(parts[0]); err != nil {
+		return
+	}
+	gid, err = strconv.Atoi(parts[1])
+	return
+}
+
+// Options converts config into options of NewWriter
+func (c Config) Options() ([]OptionWrapper, error) {
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	var opts []OptionWrapper
+	rt := RotateNone
+	if c.Rotate != "" {
+		rt, _ = ParseRotateType(c.Rotate)
+	}
+	opts = append(opts, RotateBy(rt), CreateShortcut(c.CreateShortcut))
+	if c.BufferSize > 0 {
+		opts = append(opts, BufferSize(c.BufferSize))
+	}
+	if c.FlushInterval > 0 {
+		opts = append(opts, FlushInterval(time.Duration(c.FlushInterval)))
+	}
+	if c.Keep > 0 {
+		opts = append(opts, Keep(c.Keep))
+	}
+	if c.KeepFor > 0 {
+		opts = append(opts, Keep(int(time.Duration(c.KeepFor)/rotatePeriods[rt])))
+	}
+	if c.KeepMaxSize > 0 {
+		opts = append(opts, KeepMaxSize(int64(c.KeepMaxSize)))
+	}
+	if c.CleanupInterval > 0 {
+		opts = append(opts, CleanupInterval(time.Duration(c.CleanupInterval)))
+	}
+	if c.DisableWatch {
+		opts = append(opts, DisableWatchFile())
+	}
+	if c.EnsureNewline {
+		opts = append(opts, EnsureNewline())
+	}
+	if c.MaxRecordSize > 0 {
+		opts = append(opts, MaxRecordSize(int(c.MaxRecordSize)))
+	}
+	if c.BatchSize > 0 {
+		opts = append(opts, BatchSize(c.BatchSize))
+	}
+	if c.LowLatency {
+		opts = append(opts, LowLatency())
+	}
+	if c.DropOnOverflow {
+		opts = append(opts, DropOnOverflow())
+	}
+	if c.FallbackBuffer > 0 {
+		opts = append(opts, FallbackBuffer(int64(c.FallbackBuffer)))
+	}
+	if c.FallbackDir != "" {
+		opts = append(opts, FallbackDir(c.FallbackDir))
+	}
+	if c.ProbeInterval > 0 {
+		opts = append(opts, ProbeInterval(time.Duration(c.ProbeInterval)))
+	}
+	if c.MinFreeSpace > 0 {
+		opts = append(opts, MinFreeSpace(uint64(c.MinFreeSpace)))
+	}
+	if c.MinFreePercent > 0 {
+		opts = append(opts, MinFreePercent(c.MinFreePercent))
+	}
+	if c.ArchiveDir != "" {
+		opts = append(opts, ArchiveDir(c.ArchiveDir))
+	}
+	if c.Manifest {
+		opts = append(opts, SegmentManifest(c.ManifestPath))
+	}
+	if c.Audit {
+		opts = append(opts, AuditMode())
+	}
+	if c.DedupWindow > 0 {
+		opts = append(opts, Dedup(time.Duration(c.DedupWindow), nil))
+	}
+	if c.RateLimit > 0 {
+		opts = append(opts, RateLimit(c.RateLimit, c.RateBurst))
+	}
+	if c.SampleFirst > 0 {
+		opts = append(opts, Sample(c.SampleFirst, c.SampleThereafter))
+	}
+	if mode, _ := parseMode(c.FileMode); mode != 0 {
+		opts = append(opts, FileMode(mode))
+	}
+	if mode, _ := parseMode(c.DirMode); mode != 0 {
+		opts = append(opts, DirMode(mode))
+	}
+	if c.Owner != "" {
+		uid, gid, _ := parseOwner(c.Owner)
+		opts = append(opts, Chown(uid, gid))
+	}
+	if c.MkdirAll {
+		opts = append(opts, MkdirAll())
+	}
+	if c.ShortcutMode != "" {
+		mode, _ := ParseShortcutMode(c.ShortcutMode)
+		opts = append(opts, ShortcutLink(mode))
+	}
+	if c.ShortcutName != "" {
+		opts = append(opts, ShortcutName(c.ShortcutName))
+	}
+	if c.RingSize > 0 {
+		opts = append(opts, RingFile(int64(c.RingSize)))
+	}
+	return opts, nil
+}
+
+// NewWriterFromConfig create writer from config, extra options are applied
+// after the config, e.g. options which can't be declared like Encrypt
+func NewWriterFromConfig(c Config, extra ...OptionWrapper) (FileLogWriter, error) {
+	opts, err := c.Options()
+	if err != nil {
+		return nil, err
+	}
+	return NewWriter(c.Filename, append(opts, extra...)...)
+}
+
+// LoadConfigFile read JSON config file
+func LoadConfigFile(path string) (Config, error) {
+	var c Config
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return c, err
+	}
+	if err = json.Unmarshal(data, &c); err != nil {
+		return c, fmt.Errorf("parse %s: %v", path, err)
+	}
+	return c, nil
+}
+
+// LoadConfigFromEnv read config from environment variables named by the env
+// tags with prefix, e.g. prefix APP_LOG_ reads APP_LOG_ROTATE
+func LoadConfigFromEnv(prefix string) (Config, error) {
+	var c Config
+	var errs ConfigErrors
+	v := reflect.ValueOf(&c).Elem()
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		name := prefix + t.Field(i).Tag.Get("env")
+		raw, ok := os.LookupEnv(name)
+		if !ok {
+			continue
+		}
+		if err := setField(v.Field(i), raw); err != nil {
+			errs = append(errs, ConfigError{Field: name, Value: raw, Reason: err.Error()})
+		}
+	}
+	if len(errs) > 0 {
+		return c, errs
+	}
+	return c, nil
+}
+
+func setField(f reflect.Value, raw string) error {
+	switch f.Addr().Interface().(type) {
+	case *ByteSize, *Duration:
+		return f.Addr().Interface().(interface{ UnmarshalText([]byte) error }).UnmarshalText([]byte(raw))
+	}
+	switch f.Kind() {
+	case reflect.String:
+		f.SetString(raw)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return fmt.Errorf("want true or false")
+		}
+		f.SetBool(b)
+	case reflect.Int:
+		n, err := strconv.Atoi(raw)
+		if err != nil {
+			return fmt.Errorf("want an integer")
+		}
+		f.SetInt(int64(n))
+	case reflect.Uint64:
+		n, err := strconv.ParseUint(raw, 10, 64)
+		if err != nil {
+			return fmt.Errorf("want a positive integer")
+		}
+		f.SetUint(n)
+	case reflect.Float64:
+		n, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return fmt.Errorf("want a number")
+		}
+		f.SetFloat(n)
+	}
+	return nil
+}
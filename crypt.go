@@ -0,0 +1,354 @@
+package filelog
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+)
+
+// KeyProvider supplies AES keys(16, 24 or 32 bytes) for segment encryption
+type KeyProvider interface {
+	// CurrentKey is used for new segments
+	CurrentKey() (id string, key []byte, err error)
+	// Key looks up a key by the id stored in segment header
+	Key(id string) ([]byte, error)
+}
+
+// StaticKey is a KeyProvider with a single key
+type StaticKey struct {
+	ID     string
+	Secret []byte
+}
+
+// CurrentKey returns the static key
+func (k StaticKey) CurrentKey() (string, []byte, error) {
+	return k.ID, k.Secret, nil
+}
+
+// Key returns the static key if id matches
+func (k StaticKey) Key(id string) ([]byte, error) {
+	if id != k.ID {
+		return nil, fmt.Errorf("unknown key id %q", id)
+	}
+	return k.Secret, nil
+}
+
+// Encrypt encrypt segments with AES-GCM in authenticated chunks, records
+// are never handed to FallbackWriter or FallbackDir in plain text
+func Encrypt(kp KeyProvider) OptionWrapper {
+	return func(o *Option) {
+		o.KeyProvider = kp
+	}
+}
+
+// EncryptChunkSize max plain text bytes of one encrypted chunk, default 64K
+func EncryptChunkSize(size int) OptionWrapper {
+	return func(o *Option) {
+		o.EncryptChunkSize = size
+	}
+}
+
+// An encrypted segment starts with a header
+//
+//	magic "FLENC1" | uint16 key id length | key id | uint32 chunk size
+//
+// followed by chunks
+//
+//	uint32 sealed length | 12 bytes nonce | sealed(plain text)
+//
+// the chunk index is the additional data of every chunk so that chunks can't
+// be dropped or reordered silently. A closed segment ends with a final chunk
+// of empty plain text whose additional data also carries a final flag, so
+// dropped trailing chunks are detected too.
+const cryptMagic = "FLENC1"
+
+// ErrUnsealedSegment is returned by the reader of an encrypted segment without
+// final chunk, it's either still being written or its tail was dropped.
+var ErrUnsealedSegment = errors.New("encrypted segment has no final chunk, it is still being written or truncated")
+
+type cryptState struct {
+	kp        KeyProvider
+	chunkSize int
+	keyID     string
+	aead      cipher.AEAD
+	index     uint64
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func chunkAD(index uint64, final bool) []byte {
+	ad := make([]byte, 8, 9)
+	binary.BigEndian.PutUint64(ad, index)
+	if final {
+		ad = append(ad, 1)
+	}
+	return ad
+}
+
+// useCurrentKey prepares state for an empty segment.
+func (cs *cryptState) useCurrentKey() error {
+	id, key, err := cs.kp.CurrentKey()
+	if err != nil {
+		return err
+	}
+	if cs.aead, err = newAEAD(key); err != nil {
+		return err
+	}
+	cs.keyID, cs.index = id, 0
+	return nil
+}
+
+// resume prepares state to append to an existing encrypted segment, it
+// returns the end offset of its last complete chunk, the final chunk and a
+// partial chunk left by a crash are after it and must be cut before appending.
+// The key is unloaded if the segment can't be resumed, so that writes fail
+// instead of appending chunks the header doesn't describe.
+func (cs *cryptState) resume(path string) (int64, error) {
+	cs.aead = nil
+	fd, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer fd.Close()
+	br := bufio.NewReader(fd)
+	id, chunkSize, err := readCryptHeader(br)
+	if err != nil {
+		return 0, err
+	}
+	key, err := cs.kp.Key(id)
+	if err != nil {
+		return 0, err
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return 0, err
+	}
+	cs.keyID, cs.index = id, 0
+	offset := int64(len(cryptMagic) + 2 + len(id) + 4)
+	var lenBuf [4]byte
+	for {
+		if _, err := io.ReadFull(br, lenBuf[:]); err != nil {
+			break
+		}
+		size := int(binary.BigEndian.Uint32(lenBuf[:]))
+		if size < aead.Overhead() || size > chunkSize+aead.Overhead() {
+			break
+		}
+		chunk := make([]byte, aead.NonceSize()+size)
+		if _, err := io.ReadFull(br, chunk); err != nil {
+			break
+		}
+		if size == aead.Overhead() {
+			nonce, sealed := chunk[:aead.NonceSize()], chunk[aead.NonceSize():]
+			if _, err := aead.Open(nil, nonce, sealed, chunkAD(cs.index, true)); err == nil {
+				break
+			}
+		}
+		offset += int64(4 + len(chunk))
+		cs.index++
+	}
+	cs.aead = aead
+	return offset, nil
+}
+
+// sealFinal appends the final chunk of a segment to out.
+func (cs *cryptState) sealFinal(out []byte) ([]byte, error) {
+	if cs.aead == nil {
+		return out, errors.New("encryption key not loaded")
+	}
+	nonce := make([]byte, cs.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return out, err
+	}
+	sealed := cs.aead.Seal(nil, nonce, nil, chunkAD(cs.index, true))
+	out = appendUint32(out, uint32(len(sealed)))
+	out = append(out, nonce...)
+	return append(out, sealed...), nil
+}
+
+// seal appends the encrypted form of p to out, with a segment header if
+// header is set, it returns the chunk index after p.
+func (cs *cryptState) seal(out, p []byte, header bool) ([]byte, uint64, error) {
+	if header {
+		if err := cs.useCurrentKey(); err != nil {
+			return out, cs.index, err
+		}
+		out = append(out, cryptMagic...)
+		out = appendUint16(out, uint16(len(cs.keyID)))
+		out = append(out, cs.keyID...)
+		out = appendUint32(out, uint32(cs.chunkSize))
+	}
+	if cs.aead == nil {
+		return out, cs.index, errors.New("encryption key not loaded")
+	}
+	index := cs.index
+	nonce := make([]byte, cs.aead.NonceSize())
+	for len(p) > 0 {
+		n := len(p)
+		if n > cs.chunkSize {
+			n = cs.chunkSize
+		}
+		if _, err := rand.Read(nonce); err != nil {
+			return out, cs.index, err
+		}
+		sealed := cs.aead.Seal(nil, nonce, p[:n], chunkAD(index, false))
+		out = appendUint32(out, uint32(len(sealed)))
+		out = append(out, nonce...)
+		out = append(out, sealed...)
+		p = p[n:]
+		index++
+	}
+	return out, index, nil
+}
+
+func readCryptHeader(r io.Reader) (keyID string, chunkSize int, err error) {
+	head := make([]byte, len(cryptMagic)+2)
+	if _, err = io.ReadFull(r, head); err != nil {
+		return
+	}
+	if string(head[:len(cryptMagic)]) != cryptMagic {
+		err = errors.New("not an encrypted segment")
+		return
+	}
+	id := make([]byte, binary.BigEndian.Uint16(head[len(cryptMagic):]))
+	if _, err = io.ReadFull(r, id); err != nil {
+		return
+	}
+	var size [4]byte
+	if _, err = io.ReadFull(r, size[:]); err != nil {
+		return
+	}
+	return string(id), int(binary.BigEndian.Uint32(size[:])), nil
+}
+
+type decryptReader struct {
+	r         *bufio.Reader
+	aead      cipher.AEAD
+	chunkSize int
+	index     uint64
+	final     bool
+	buf       []byte
+	err       error
+}
+
+// NewDecryptReader returns a reader of the plain text of an encrypted segment
+func NewDecryptReader(r io.Reader, kp KeyProvider) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	id, chunkSize, err := readCryptHeader(br)
+	if err != nil {
+		return nil, err
+	}
+	if chunkSize <= 0 || chunkSize > 16*M {
+		return nil, fmt.Errorf("bad chunk size %d", chunkSize)
+	}
+	key, err := kp.Key(id)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	return &decryptReader{r: br, aead: aead, chunkSize: chunkSize}, nil
+}
+
+func (dr *decryptReader) Read(p []byte) (int, error) {
+	for len(dr.buf) == 0 {
+		if dr.err != nil {
+			return 0, dr.err
+		}
+		dr.next()
+	}
+	n := copy(p, dr.buf)
+	dr.buf = dr.buf[n:]
+	return n, nil
+}
+
+func (dr *decryptReader) next() {
+	var lenBuf [4]byte
+	if _, err := io.ReadFull(dr.r, lenBuf[:]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			err = errors.New("truncated chunk")
+		} else if err == io.EOF && !dr.final {
+			err = ErrUnsealedSegment
+		}
+		dr.err = err
+		return
+	}
+	if dr.final {
+		dr.err = fmt.Errorf("chunk %d: data after final chunk", dr.index)
+		return
+	}
+	size := binary.BigEndian.Uint32(lenBuf[:])
+	if size < uint32(dr.aead.Overhead()) || size > uint32(dr.chunkSize+dr.aead.Overhead()) {
+		dr.err = fmt.Errorf("chunk %d: bad sealed length %d", dr.index, size)
+		return
+	}
+	chunk := make([]byte, dr.aead.NonceSize()+int(size))
+	if _, err := io.ReadFull(dr.r, chunk); err != nil {
+		dr.err = errors.New("truncated chunk")
+		return
+	}
+	nonce, sealed := chunk[:dr.aead.NonceSize()], chunk[dr.aead.NonceSize():]
+	// only the final chunk has empty plain text
+	dr.final = len(sealed) == dr.aead.Overhead()
+	plain, err := dr.aead.Open(sealed[:0], nonce, sealed, chunkAD(dr.index, dr.final))
+	if err != nil {
+		dr.err = fmt.Errorf("chunk %d: %v", dr.index, err)
+		return
+	}
+	dr.index++
+	dr.buf = plain
+}
+
+// sealSegment ends the current encrypted segment with its final chunk.
+func (w *fWriter) sealSegment() {
+	if w.crypt == nil || w.crypt.aead == nil || w.file == nil || w.stats.size == 0 {
+		return
+	}
+	out, err := w.crypt.sealFinal(nil)
+	if err == nil {
+		_, err = w.writeFull(out)
+	}
+	if err != nil {
+		log.Printf("[filelog] seal encrypted segment %s fail %v\n", w.realFilename, err)
+	}
+}
+
+// openPlain opens a segment of this writer for reading its plain text.
+func (w *fWriter) openPlain(path string) (io.ReadCloser, error) {
+	fd, err := os.Open(path)
+	if err != nil || w.crypt == nil {
+		return fd, err
+	}
+	r, err := NewDecryptReader(fd, w.crypt.kp)
+	if err != nil {
+		fd.Close()
+		return nil, err
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{r, fd}, nil
+}
+
+func appendUint16(b []byte, v uint16) []byte {
+	return append(b, byte(v>>8), byte(v))
+}
+
+func appendUint32(b []byte, v uint32) []byte {
+	return append(b, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
@@ -0,0 +1,121 @@
+package filelog
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var testKey = StaticKey{ID: "k1", Secret: bytes.Repeat([]byte{7}, 32)}
+
+// writeRecords writes records with a new writer and closes it.
+func writeRecords(t *testing.T, path string, records []string, wrappers ...OptionWrapper) {
+	t.Helper()
+	w, err := NewWriter(path, append([]OptionWrapper{DisableWatchFile()}, wrappers...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range records {
+		if _, err := w.Write([]byte(r)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func decryptFile(t *testing.T, path string) (string, error) {
+	t.Helper()
+	fd, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fd.Close()
+	r, err := NewDecryptReader(fd, testKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain, err := ioutil.ReadAll(r)
+	return string(plain), err
+}
+
+func TestEncryptReopenSealedSegment(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+	writeRecords(t, path, []string{"a\n", "b\n"}, Encrypt(testKey), EncryptChunkSize(4))
+	writeRecords(t, path, []string{"c\n"}, Encrypt(testKey), EncryptChunkSize(4))
+	plain, err := decryptFile(t, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plain != "a\nb\nc\n" {
+		t.Fatalf("got %q", plain)
+	}
+}
+
+func TestEncryptDroppedFinalChunk(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+	writeRecords(t, path, []string{"a\n", "b\n"}, Encrypt(testKey))
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// length, nonce and tag of the final chunk
+	if err := os.Truncate(path, fi.Size()-4-12-16); err != nil {
+		t.Fatal(err)
+	}
+	plain, err := decryptFile(t, path)
+	if err != ErrUnsealedSegment {
+		t.Fatalf("expect ErrUnsealedSegment, got %v", err)
+	}
+	if plain != "a\nb\n" {
+		t.Fatalf("got %q", plain)
+	}
+}
+
+func TestEncryptResumeAfterPartialChunk(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+	writeRecords(t, path, []string{"a\n", "b\n"}, Encrypt(testKey))
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a crash while writing a chunk leaves the segment unsealed with a
+	// partial chunk at its tail
+	if err := os.Truncate(path, fi.Size()-4-12-16); err != nil {
+		t.Fatal(err)
+	}
+	fd, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fd.Write([]byte{0, 0, 0, 40, 1, 2, 3})
+	fd.Close()
+	writeRecords(t, path, []string{"c\n"}, Encrypt(testKey))
+	plain, err := decryptFile(t, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plain != "a\nb\nc\n" {
+		t.Fatalf("got %q", plain)
+	}
+}
+
+func TestEncryptResumeUnknownKey(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+	writeRecords(t, path, []string{"a\n"}, Encrypt(testKey))
+	// state left by the previous segment written with another key
+	cs := &cryptState{kp: StaticKey{ID: "k2", Secret: bytes.Repeat([]byte{9}, 32)}, chunkSize: 16}
+	if err := cs.useCurrentKey(); err != nil {
+		t.Fatal(err)
+	}
+	cs.index = 5
+	if _, err := cs.resume(path); err == nil {
+		t.Fatal("expect unknown key error")
+	}
+	if _, _, err := cs.seal(nil, []byte("b\n"), false); err == nil {
+		t.Fatal("expect seal to fail after a failed resume")
+	}
+}
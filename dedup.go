@@ -0,0 +1,100 @@
+package filelog
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+)
+
+// Dedup collapse consecutive identical records within window into one record
+// followed by "last message repeated N times", normalize maps a record to the
+// form compared, e.g. stripping timestamps, nil compares records as is
+func Dedup(window time.Duration, normalize func(record []byte) []byte) OptionWrapper {
+	return func(o *Option) {
+		o.DedupWindow = window
+		o.DedupNormalize = normalize
+	}
+}
+
+// dedup is only touched by the writing goroutine.
+type dedup struct {
+	window    time.Duration
+	normalize func([]byte) []byte
+	last      []byte
+	since     time.Time
+	repeats   int
+	// armed is signaled when records start repeating
+	armed chan struct{}
+}
+
+func newDedup(window time.Duration, normalize func([]byte) []byte) *dedup {
+	return &dedup{window: window, normalize: normalize, armed: make(chan struct{}, 1)}
+}
+
+// check returns the summary of collapsed records to write before p if any,
+// keep is false when p repeats the last record.
+func (d *dedup) check(p []byte, now time.Time) (summary []byte, keep bool) {
+	key := p
+	if d.normalize != nil {
+		key = d.normalize(p)
+	}
+	if d.last != nil && bytes.Equal(key, d.last) && now.Sub(d.since) < d.window {
+		d.repeats++
+		if d.repeats == 1 {
+			select {
+			case d.armed <- struct{}{}:
+			default:
+			}
+		}
+		return nil, false
+	}
+	summary = d.flush()
+	d.last = append(d.last[:0], key...)
+	d.since = now
+	return summary, true
+}
+
+// expire returns the summary of collapsed records once the window of the
+// last record is over.
+func (d *dedup) expire(now time.Time) []byte {
+	if d.repeats == 0 || now.Sub(d.since) < d.window {
+		return nil
+	}
+	return d.flush()
+}
+
+// flush returns the summary of collapsed records and resets the counter.
+func (d *dedup) flush() []byte {
+	if d.repeats == 0 {
+		return nil
+	}
+	summary := []byte(fmt.Sprintf("last message repeated %d times\n", d.repeats))
+	d.repeats = 0
+	return summary
+}
+
+// expireDedup writes the pending summary when repeats stop, so it doesn't
+// wait for a different record. It only wakes up once records repeat.
+func (fw *fileLogWriter) expireDedup() {
+	w := fw.fwriter
+	for {
+		select {
+		case <-w.dedup.armed:
+		case <-w.closeCh:
+			return
+		}
+		timer := time.NewTimer(w.dedup.window)
+		select {
+		case <-timer.C:
+			fw.Writer.Do(func() {
+				if summary := w.dedup.expire(time.Now()); summary != nil {
+					w.push(summary)
+					w.flushBatch()
+				}
+			})
+		case <-w.closeCh:
+			timer.Stop()
+			return
+		}
+	}
+}
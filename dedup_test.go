@@ -0,0 +1,50 @@
+package filelog
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDedupFlushesSummaryWhenWindowExpires(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+	w, err := NewWriter(path, Dedup(50*time.Millisecond, nil), DisableWatchFile())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	for i := 0; i < 3; i++ {
+		w.Write([]byte("same\n"))
+	}
+	expect := "same\nlast message repeated 2 times\n"
+	var data []byte
+	for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if data, _ = ioutil.ReadFile(path); string(data) == expect {
+			return
+		}
+	}
+	t.Fatalf("got %q before close, expect %q", data, expect)
+}
+
+func TestDedupArmsExpiryOnlyOnRepeats(t *testing.T) {
+	d := newDedup(time.Minute, nil)
+	now := time.Now()
+	d.check([]byte("a\n"), now)
+	d.check([]byte("b\n"), now)
+	select {
+	case <-d.armed:
+		t.Fatal("armed without repeats")
+	default:
+	}
+	d.check([]byte("b\n"), now)
+	d.check([]byte("b\n"), now)
+	select {
+	case <-d.armed:
+	default:
+		t.Fatal("not armed after a repeat")
+	}
+	if summary := d.expire(now.Add(time.Minute)); string(summary) != "last message repeated 2 times\n" {
+		t.Fatalf("got summary %q", summary)
+	}
+}
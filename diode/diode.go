@@ -4,6 +4,7 @@ package diode
 
 import (
 	"context"
+	"errors"
 	"io"
 	"sync"
 	"time"
@@ -19,14 +20,54 @@ var bufPool = &sync.Pool{
 
 type Alerter func(missed int)
 
+// BatchWriter is implemented by writers which can write several records at
+// once, the poller then drains all available records and hands them over in
+// one call instead of one Write per record.
+type BatchWriter interface {
+	WriteBatch(records [][]byte) error
+}
+
+// Option configures a Writer.
+type Option func(*Writer)
+
+// WithWaiter makes the consumer sleep until a producer wakes it up instead
+// of polling the diode every poll interval, which trades a channel send per
+// Write for lower latency and no idle wake-ups.
+func WithWaiter() Option {
+	return func(dw *Writer) {
+		dw.waiter = true
+	}
+}
+
+// WithBatchSize sets the max records drained per WriteBatch call, default 128.
+func WithBatchSize(size int) Option {
+	return func(dw *Writer) {
+		if size > 0 {
+			dw.batchSize = size
+		}
+	}
+}
+
 // Writer is a io.Writer wrapper that uses a diode to make Write lock-free,
 // non-blocking and thread safe.
 type Writer struct {
-	w    io.Writer
-	d    *diodes.ManyToOne
-	p    *diodes.Poller
-	c    context.CancelFunc
-	done chan struct{}
+	w           io.Writer
+	d           *lanes
+	p           consumer
+	ctx         context.Context
+	c           context.CancelFunc
+	done        chan struct{}
+	batchSize   int
+	waiter      bool
+	overflow    OverflowPolicy
+	laneConfigs []Lane
+	laneAlerter LaneAlerter
+}
+
+// consumer is either a diodes.Poller or a diodes.Waiter.
+type consumer interface {
+	diodes.Diode
+	Next() diodes.GenericDataType
 }
 
 // NewWriter creates a writer wrapping w with a many-to-one diode in order to
@@ -42,30 +83,98 @@ type Writer struct {
 //
 //
 // See code.cloudfoundry.org/go-diodes for more info on diode.
-func NewWriter(w io.Writer, size int, poolInterval time.Duration, f Alerter) Writer {
+func NewWriter(w io.Writer, size int, poolInterval time.Duration, f Alerter, opts ...Option) Writer {
 	ctx, cancel := context.WithCancel(context.Background())
-	d := diodes.NewManyToOne(size, diodes.AlertFunc(f))
 	dw := Writer{
-		w: w,
-		d: d,
-		p: diodes.NewPoller(d,
+		w:         w,
+		ctx:       ctx,
+		c:         cancel,
+		done:      make(chan struct{}),
+		batchSize: 128,
+	}
+	for _, opt := range opts {
+		opt(&dw)
+	}
+	if dw.laneAlerter == nil {
+		dw.laneAlerter = func(_ string, missed int) {
+			if f != nil {
+				f(missed)
+			}
+		}
+	}
+	def := Lane{Name: DefaultLane, Size: size, Overflow: dw.overflow}
+	d := newLanes(def, dw.laneConfigs, dw.laneAlerter)
+	dw.d = d
+	if dw.waiter {
+		dw.p = diodes.NewWaiter(d, diodes.WithWaiterContext(ctx))
+	} else {
+		dw.p = diodes.NewPoller(d,
 			diodes.WithPollingInterval(poolInterval),
-			diodes.WithPollingContext(ctx)),
-		c:    cancel,
-		done: make(chan struct{}),
+			diodes.WithPollingContext(ctx))
 	}
 	go dw.poll()
 	return dw
 }
 
 func (dw Writer) Write(p []byte) (n int, err error) {
+	return dw.WriteLane(DefaultLane, p)
+}
+
+// WriteLane writes p into the named lane, unknown names go to the default
+// lane. A record dropped by the Drop overflow policy is reported to the
+// alerter and not returned as an error.
+func (dw Writer) WriteLane(name string, p []byte) (n int, err error) {
 	// p is pooled in zerolog so we can't hold it passed this call, hence the
 	// copy.
 	p = append(bufPool.Get().([]byte), p...)
-	dw.d.Set(diodes.GenericDataType(&p))
+	if !dw.d.lane(name).put(diodes.GenericDataType(&entry{p: p})) {
+		bufPool.Put(p[:0])
+		return len(p), nil
+	}
+	dw.notify()
 	return len(p), nil
 }
 
+// ErrClosed is returned by Do when the writer is closed.
+var ErrClosed = errors.New("diode: writer closed")
+
+// Do runs fn in the consumer goroutine and waits for it to return. It is a
+// barrier across all lanes: records written to any lane before Do are
+// consumed before fn, records written after Do returns are consumed after.
+func (dw Writer) Do(fn func()) error {
+	if dw.ctx.Err() != nil {
+		return ErrClosed
+	}
+	e := &entry{fn: fn, done: make(chan struct{})}
+	dw.d.addBarrier(e)
+	dw.notify()
+	select {
+	case <-e.done:
+		return nil
+	case <-dw.done:
+		select {
+		case <-e.done:
+			return nil
+		default:
+			return ErrClosed
+		}
+	}
+}
+
+// SetPollInterval changes the interval of the poller, it must be called in
+// a Do callback.
+func (dw Writer) SetPollInterval(interval time.Duration) {
+	if p, ok := dw.p.(*diodes.Poller); ok {
+		p.SetInterval(interval)
+	}
+}
+
+func (dw Writer) notify() {
+	if wt, ok := dw.p.(*diodes.Waiter); ok {
+		wt.Notify()
+	}
+}
+
 // Close releases the diode poller and call Close on the wrapped writer if
 // io.Closer is implemented.
 func (dw Writer) Close() error {
@@ -77,15 +186,74 @@ func (dw Writer) Close() error {
 	return nil
 }
 
+// entry is either a record or a command to run in the consumer goroutine.
+type entry struct {
+	p    []byte
+	fn   func()
+	done chan struct{}
+}
+
+func (e *entry) run() {
+	e.fn()
+	close(e.done)
+}
+
 func (dw Writer) poll() {
 	defer close(dw.done)
+	if bw, ok := dw.w.(BatchWriter); ok {
+		dw.pollBatch(bw)
+		return
+	}
 	for {
 		d := dw.p.Next()
 		if d == nil {
 			return
 		}
-		p := *(*[]byte)(d)
-		dw.w.Write(p)
-		bufPool.Put(p[:0])
+		e := (*entry)(d)
+		if e.fn != nil {
+			e.run()
+			continue
+		}
+		dw.w.Write(e.p)
+		bufPool.Put(e.p[:0])
+		dw.d.reportDrops()
+	}
+}
+
+func (dw Writer) pollBatch(bw BatchWriter) {
+	records := make([][]byte, 0, dw.batchSize)
+	flush := func() {
+		if len(records) > 0 {
+			bw.WriteBatch(records)
+		}
+		for i, p := range records {
+			bufPool.Put(p[:0])
+			records[i] = nil
+		}
+		records = records[:0]
+		dw.d.reportDrops()
+	}
+	for {
+		d := dw.p.Next()
+		if d == nil {
+			return
+		}
+		for {
+			if e := (*entry)(d); e.fn != nil {
+				// records before the command are written first
+				flush()
+				e.run()
+			} else {
+				records = append(records, e.p)
+			}
+			if len(records) >= dw.batchSize {
+				break
+			}
+			var ok bool
+			if d, ok = dw.p.TryNext(); !ok {
+				break
+			}
+		}
+		flush()
 	}
 }
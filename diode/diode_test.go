@@ -0,0 +1,239 @@
+package diode
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/qjpcpu/filelog/diode/internal/diodes"
+)
+
+var record = []byte(`{"level":"info","msg":"request served","status":200,"latency_ms":3}` + "\n")
+
+// countingFile counts write syscalls issued to the file.
+type countingFile struct {
+	f      *os.File
+	writes int64
+}
+
+func (c *countingFile) Write(p []byte) (int, error) {
+	atomic.AddInt64(&c.writes, 1)
+	return c.f.Write(p)
+}
+
+// batchFile writes every batch with a single write syscall.
+type batchFile struct {
+	countingFile
+	buf []byte
+}
+
+func (b *batchFile) WriteBatch(records [][]byte) error {
+	b.buf = b.buf[:0]
+	for _, p := range records {
+		b.buf = append(b.buf, p...)
+	}
+	_, err := b.countingFile.Write(b.buf)
+	return err
+}
+
+func openBenchFile(b *testing.B) *os.File {
+	dir, err := ioutil.TempDir("", "diode")
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(func() { os.RemoveAll(dir) })
+	f, err := os.OpenFile(filepath.Join(dir, "bench.log"), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(func() { f.Close() })
+	return f
+}
+
+func benchmarkWrites(b *testing.B, w interface{ Write([]byte) (int, error) }, counter *countingFile) {
+	dw := NewWriter(w, 1024, time.Millisecond, nil)
+	b.SetBytes(int64(len(record)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		dw.Write(record)
+	}
+	dw.Close()
+	b.StopTimer()
+	b.ReportMetric(float64(atomic.LoadInt64(&counter.writes))/float64(b.N), "syscalls/record")
+}
+
+// BenchmarkPerRecordWrite is the path without BatchWriter, one write per record.
+func BenchmarkPerRecordWrite(b *testing.B) {
+	cf := &countingFile{f: openBenchFile(b)}
+	benchmarkWrites(b, cf, cf)
+}
+
+// BenchmarkBatchedWrite drains available records into one WriteBatch call.
+func BenchmarkBatchedWrite(b *testing.B) {
+	bf := &batchFile{countingFile: countingFile{f: openBenchFile(b)}}
+	benchmarkWrites(b, bf, &bf.countingFile)
+}
+
+// recorder keeps all records written to it.
+type recorder struct {
+	buf bytes.Buffer
+}
+
+func (r *recorder) Write(p []byte) (int, error) {
+	return r.buf.Write(p)
+}
+
+func (r *recorder) WriteBatch(records [][]byte) error {
+	for _, p := range records {
+		r.buf.Write(p)
+	}
+	return nil
+}
+
+func TestWriteBatchKeepsOrder(t *testing.T) {
+	r := &recorder{}
+	dw := NewWriter(r, 1024, time.Millisecond, nil, WithBatchSize(7))
+	var want bytes.Buffer
+	for i := 0; i < 500; i++ {
+		p := []byte{byte('a' + i%26), '\n'}
+		want.Write(p)
+		dw.Write(p)
+	}
+	dw.Close()
+	if !bytes.Equal(r.buf.Bytes(), want.Bytes()) {
+		t.Fatalf("records out of order or lost, got %d bytes want %d", r.buf.Len(), want.Len())
+	}
+}
+
+// signalWriter reports every consumed batch.
+type signalWriter struct {
+	ch chan struct{}
+}
+
+func (s *signalWriter) Write(p []byte) (int, error) {
+	s.ch <- struct{}{}
+	return len(p), nil
+}
+
+func (s *signalWriter) WriteBatch(records [][]byte) error {
+	for range records {
+		s.ch <- struct{}{}
+	}
+	return nil
+}
+
+// benchmarkLatency measures the time from Write to the record being consumed
+// for isolated records, which is what a burst after an idle period sees.
+func benchmarkLatency(b *testing.B, opts ...Option) {
+	sw := &signalWriter{ch: make(chan struct{}, 1)}
+	dw := NewWriter(sw, 1024, 10*time.Millisecond, nil, opts...)
+	defer dw.Close()
+	var total time.Duration
+	for i := 0; i < b.N; i++ {
+		start := time.Now()
+		dw.Write(record)
+		<-sw.ch
+		total += time.Since(start)
+	}
+	b.ReportMetric(float64(total.Microseconds())/float64(b.N), "us/record")
+}
+
+func BenchmarkLatencyPoller(b *testing.B) {
+	benchmarkLatency(b)
+}
+
+func BenchmarkLatencyWaiter(b *testing.B) {
+	benchmarkLatency(b, WithWaiter())
+}
+
+// countingDiode counts how often the consumer looks for data.
+type countingDiode struct {
+	diodes.Diode
+	tries int64
+}
+
+func (c *countingDiode) TryNext() (diodes.GenericDataType, bool) {
+	atomic.AddInt64(&c.tries, 1)
+	return c.Diode.TryNext()
+}
+
+// benchmarkIdleWakeups reports how often an idle consumer wakes up.
+func benchmarkIdleWakeups(b *testing.B, waiter bool) {
+	const idle = 100 * time.Millisecond
+	var tries int64
+	for i := 0; i < b.N; i++ {
+		ctx, cancel := context.WithCancel(context.Background())
+		cd := &countingDiode{Diode: diodes.NewManyToOne(16, nil)}
+		var c consumer
+		if waiter {
+			c = diodes.NewWaiter(cd, diodes.WithWaiterContext(ctx))
+		} else {
+			c = diodes.NewPoller(cd, diodes.WithPollingInterval(10*time.Millisecond), diodes.WithPollingContext(ctx))
+		}
+		done := make(chan struct{})
+		go func() {
+			c.Next()
+			close(done)
+		}()
+		time.Sleep(idle)
+		tries += atomic.LoadInt64(&cd.tries)
+		cancel()
+		<-done
+	}
+	b.ReportMetric(float64(tries)/float64(b.N)/idle.Seconds(), "wakeups/s")
+}
+
+func BenchmarkIdleWakeupsPoller(b *testing.B) {
+	benchmarkIdleWakeups(b, false)
+}
+
+func BenchmarkIdleWakeupsWaiter(b *testing.B) {
+	benchmarkIdleWakeups(b, true)
+}
+
+func TestWaiterNeverLosesWakeup(t *testing.T) {
+	sw := &signalWriter{ch: make(chan struct{}, 1)}
+	dw := NewWriter(sw, 1024, time.Hour, nil, WithWaiter())
+	defer dw.Close()
+	for i := 0; i < 10000; i++ {
+		dw.Write(record)
+		select {
+		case <-sw.ch:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("record %d not consumed, wakeup lost", i)
+		}
+	}
+}
+
+func TestDoIsBarrierAcrossLanes(t *testing.T) {
+	r := &recorder{}
+	dw := NewWriter(r, 64, time.Millisecond, nil,
+		WithLane(Lane{Name: "low", Priority: -1, Size: 64}),
+		WithLane(Lane{Name: "high", Priority: 1, Size: 64}))
+	defer dw.Close()
+	release := make(chan struct{})
+	go dw.Do(func() { <-release })
+	time.Sleep(10 * time.Millisecond)
+	// consumer is busy, records pile up in the lanes
+	dw.WriteLane("low", []byte("L"))
+	dw.Write([]byte("D"))
+	done := make(chan struct{})
+	go func() {
+		dw.Do(func() { r.buf.WriteString("|") })
+		close(done)
+	}()
+	time.Sleep(10 * time.Millisecond)
+	dw.WriteLane("high", []byte("H"))
+	close(release)
+	<-done
+	dw.Do(func() {})
+	if got := r.buf.String(); got != "DL|H" {
+		t.Fatalf("got %q, want records before the barrier first", got)
+	}
+}
@@ -0,0 +1,39 @@
+//go:build linux || darwin
+// +build linux darwin
+
+package diode
+
+import (
+	"io/ioutil"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func cpuTime() time.Duration {
+	var ru syscall.Rusage
+	syscall.Getrusage(syscall.RUSAGE_SELF, &ru)
+	return time.Duration(ru.Utime.Nano() + ru.Stime.Nano())
+}
+
+// benchmarkIdleCPU reports process CPU time spent while a writer is idle.
+func benchmarkIdleCPU(b *testing.B, opts ...Option) {
+	const idle = 200 * time.Millisecond
+	var used time.Duration
+	for i := 0; i < b.N; i++ {
+		dw := NewWriter(ioutil.Discard, 1024, 10*time.Millisecond, nil, opts...)
+		start := cpuTime()
+		time.Sleep(idle)
+		used += cpuTime() - start
+		dw.Close()
+	}
+	b.ReportMetric(float64(used.Microseconds())/float64(b.N)/idle.Seconds(), "cpu-us/s")
+}
+
+func BenchmarkIdleCPUPoller(b *testing.B) {
+	benchmarkIdleCPU(b)
+}
+
+func BenchmarkIdleCPUWaiter(b *testing.B) {
+	benchmarkIdleCPU(b, WithWaiter())
+}
@@ -44,7 +44,8 @@ func (d *ManyToOne) mod(num uint64) uint64 {
 	return num & (uint64(len(d.buffer)) - 1)
 }
 
-// Set sets the data in the next slot of the ring buffer.
+// Set sets the data in the next slot of the ring buffer. It waits for the
+// reader when the buffer is full.
 func (d *ManyToOne) Set(data GenericDataType) {
 	for {
 		count := atomic.LoadInt64(&d.holesCount)
@@ -53,6 +54,26 @@ func (d *ManyToOne) Set(data GenericDataType) {
 		}
 		runtime.Gosched()
 	}
+	d.set(data)
+}
+
+// TrySet sets the data in the next slot of the ring buffer unless the buffer
+// is full, in which case it returns false without blocking.
+func (d *ManyToOne) TrySet(data GenericDataType) bool {
+	for {
+		count := atomic.LoadInt64(&d.holesCount)
+		if count >= int64(len(d.buffer)) {
+			return false
+		}
+		if atomic.CompareAndSwapInt64(&d.holesCount, count, count+1) {
+			break
+		}
+	}
+	d.set(data)
+	return true
+}
+
+func (d *ManyToOne) set(data GenericDataType) {
 	for {
 		writeIndex := atomic.AddUint64(&d.writeIndex, 1)
 		idx := d.mod(writeIndex)
@@ -79,6 +100,18 @@ func (d *ManyToOne) Set(data GenericDataType) {
 	}
 }
 
+// Claimed returns the seq the next Set will take, every seq before it is
+// already claimed by a writer.
+func (d *ManyToOne) Claimed() uint64 {
+	return atomic.LoadUint64(&d.writeIndex) + 1
+}
+
+// ReadIndex returns the seq of the next read, it must only be called by the
+// reader.
+func (d *ManyToOne) ReadIndex() uint64 {
+	return d.readIndex
+}
+
 // TryNext will attempt to read from the next slot of the ring buffer.
 // If there is not data available, it will return (nil, false).
 func (d *ManyToOne) TryNext() (data GenericDataType, ok bool) {
@@ -53,6 +53,12 @@ func NewPoller(d Diode, opts ...PollerConfigOption) *Poller {
 	return p
 }
 
+// SetInterval changes the polling interval, it must be called on the
+// goroutine calling Next.
+func (p *Poller) SetInterval(interval time.Duration) {
+	p.interval = interval
+}
+
 // Next polls the diode until data is available or until the context is done.
 // If the context is done, then nil will be returned.
 func (p *Poller) Next() GenericDataType {
@@ -2,16 +2,14 @@ package diodes
 
 import (
 	"context"
-	"sync"
 )
 
-// Waiter will use a conditional mutex to alert the reader to when data is
+// Waiter will use a notification channel to alert the reader to when data is
 // available.
 type Waiter struct {
 	Diode
-	mu  sync.Mutex
-	c   *sync.Cond
-	ctx context.Context
+	notify chan struct{}
+	ctx    context.Context
 }
 
 // WaiterConfigOption can be used to setup the waiter.
@@ -30,35 +28,36 @@ func WithWaiterContext(ctx context.Context) WaiterConfigOption {
 func NewWaiter(d Diode, opts ...WaiterConfigOption) *Waiter {
 	w := new(Waiter)
 	w.Diode = d
-	w.c = sync.NewCond(&w.mu)
+	w.notify = make(chan struct{}, 1)
 	w.ctx = context.Background()
 
 	for _, opt := range opts {
 		opt(w)
 	}
 
-	go func() {
-		<-w.ctx.Done()
-		w.c.Broadcast()
-	}()
-
 	return w
 }
 
-// Set invokes the wrapped diode's Set with the given data and uses Broadcast
-// to wake up any readers.
+// Set invokes the wrapped diode's Set with the given data and wakes up the
+// reader. The notification never blocks and is never lost: if the reader is
+// not waiting yet, the pending token makes its next wait return at once.
 func (w *Waiter) Set(data GenericDataType) {
 	w.Diode.Set(data)
-	w.c.Broadcast()
+	w.Notify()
+}
+
+// Notify wakes up the reader without setting any data.
+func (w *Waiter) Notify() {
+	select {
+	case w.notify <- struct{}{}:
+	default:
+	}
 }
 
 // Next returns the next data point on the wrapped diode. If there is not any
-// new data, it will Wait for set to be called or the context to be done.
+// new data, it will wait for set to be called or the context to be done.
 // If the context is done, then nil will be returned.
 func (w *Waiter) Next() GenericDataType {
-	w.mu.Lock()
-	defer w.mu.Unlock()
-
 	for {
 		data, ok := w.Diode.TryNext()
 		if !ok {
@@ -66,7 +65,10 @@ func (w *Waiter) Next() GenericDataType {
 				return nil
 			}
 
-			w.c.Wait()
+			select {
+			case <-w.notify:
+			case <-w.ctx.Done():
+			}
 			continue
 		}
 		return data
@@ -0,0 +1,221 @@
+package diode
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"github.com/qjpcpu/filelog/diode/internal/diodes"
+)
+
+// DefaultLane is the name of the lane used by Write.
+const DefaultLane = "default"
+
+// OverflowPolicy decides what a lane does with a record when it is full.
+type OverflowPolicy int
+
+const (
+	// Block makes the producer wait until the consumer frees a slot.
+	Block OverflowPolicy = iota
+	// Drop discards the record and reports it as dropped.
+	Drop
+)
+
+// Lane describes a priority lane of a Writer. Lanes with a higher Priority
+// are drained first, records keep their order within a lane.
+type Lane struct {
+	Name     string
+	Priority int
+	// Size must be a power of 2.
+	Size     int
+	Overflow OverflowPolicy
+}
+
+// LaneAlerter is notified with the number of records a lane dropped.
+type LaneAlerter func(lane string, missed int)
+
+// WithLane adds a priority lane, records are sent to it with WriteLane.
+func WithLane(l Lane) Option {
+	return func(dw *Writer) {
+		dw.laneConfigs = append(dw.laneConfigs, l)
+	}
+}
+
+// WithOverflow sets the overflow policy of the default lane, default Block.
+func WithOverflow(policy OverflowPolicy) Option {
+	return func(dw *Writer) {
+		dw.overflow = policy
+	}
+}
+
+// WithLaneAlerter reports drops together with the lane name, it takes
+// precedence over the Alerter passed to NewWriter.
+func WithLaneAlerter(f LaneAlerter) Option {
+	return func(dw *Writer) {
+		dw.laneAlerter = f
+	}
+}
+
+type lane struct {
+	Lane
+	d       *diodes.ManyToOne
+	dropped int64
+}
+
+func (l *lane) put(data diodes.GenericDataType) bool {
+	if l.Overflow == Drop {
+		if !l.d.TrySet(data) {
+			atomic.AddInt64(&l.dropped, 1)
+			return false
+		}
+		return true
+	}
+	l.d.Set(data)
+	return true
+}
+
+// lanes is a diodes.Diode which reads lanes in descending priority.
+type lanes struct {
+	sorted []*lane
+	byName map[string]*lane
+	def    *lane
+	alert  LaneAlerter
+	// commands waiting for the records written before them
+	mu       sync.Mutex
+	npending int32
+	pending  []*barrier
+	held     []heldRecord
+}
+
+// barrier is a command with the write position of every lane when it was
+// issued, it runs after all records before those positions are read and
+// before any record after them.
+type barrier struct {
+	e     *entry
+	marks []uint64
+}
+
+// heldRecord is a record read past the marks of a pending barrier.
+type heldRecord struct {
+	data diodes.GenericDataType
+	seq  uint64
+}
+
+func newLanes(def Lane, extra []Lane, alert LaneAlerter) *lanes {
+	ls := &lanes{byName: make(map[string]*lane), alert: alert}
+	for _, cfg := range append([]Lane{def}, extra...) {
+		l := &lane{Lane: cfg}
+		name := cfg.Name
+		l.d = diodes.NewManyToOne(cfg.Size, diodes.AlertFunc(func(missed int) {
+			alert(name, missed)
+		}))
+		if _, ok := ls.byName[name]; ok {
+			continue
+		}
+		ls.byName[name] = l
+		ls.sorted = append(ls.sorted, l)
+	}
+	ls.def = ls.byName[def.Name]
+	ls.held = make([]heldRecord, len(ls.sorted))
+	sort.SliceStable(ls.sorted, func(i, j int) bool {
+		return ls.sorted[i].Priority > ls.sorted[j].Priority
+	})
+	return ls
+}
+
+func (ls *lanes) lane(name string) *lane {
+	if l, ok := ls.byName[name]; ok {
+		return l
+	}
+	return ls.def
+}
+
+// Set puts data into the default lane.
+func (ls *lanes) Set(data diodes.GenericDataType) {
+	ls.def.put(data)
+}
+
+// addBarrier queues command e behind the records already written to any lane.
+func (ls *lanes) addBarrier(e *entry) {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	// announce before the snapshot, so a reader which doesn't see it has
+	// read only records before the marks
+	atomic.AddInt32(&ls.npending, 1)
+	b := &barrier{e: e, marks: make([]uint64, len(ls.sorted))}
+	for i, l := range ls.sorted {
+		b.marks[i] = l.d.Claimed()
+	}
+	ls.pending = append(ls.pending, b)
+}
+
+func (ls *lanes) firstBarrier() *barrier {
+	if atomic.LoadInt32(&ls.npending) == 0 {
+		return nil
+	}
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	if len(ls.pending) == 0 {
+		return nil
+	}
+	return ls.pending[0]
+}
+
+func (ls *lanes) popBarrier() {
+	ls.mu.Lock()
+	ls.pending[0] = nil
+	ls.pending = ls.pending[1:]
+	ls.mu.Unlock()
+	atomic.AddInt32(&ls.npending, -1)
+}
+
+// TryNext reads from the first non empty lane by priority. A pending barrier
+// is returned once every lane is read up to its marks.
+func (ls *lanes) TryNext() (diodes.GenericDataType, bool) {
+	if b := ls.firstBarrier(); b != nil {
+		for i, l := range ls.sorted {
+			if h := ls.held[i]; h.data != nil {
+				if h.seq < b.marks[i] {
+					ls.held[i] = heldRecord{}
+					return h.data, true
+				}
+				continue
+			}
+			if l.d.ReadIndex() < b.marks[i] {
+				// nil until the writer which claimed the slot stores it
+				return l.d.TryNext()
+			}
+		}
+		ls.popBarrier()
+		return diodes.GenericDataType(b.e), true
+	}
+	for i, l := range ls.sorted {
+		if h := ls.held[i]; h.data != nil {
+			ls.held[i] = heldRecord{}
+			return h.data, true
+		}
+		data, ok := l.d.TryNext()
+		if !ok {
+			continue
+		}
+		if b := ls.firstBarrier(); b != nil && l.d.ReadIndex()-1 >= b.marks[i] {
+			// written after the barrier, keep it until the barrier runs
+			ls.held[i] = heldRecord{data: data, seq: l.d.ReadIndex() - 1}
+			return ls.TryNext()
+		}
+		return data, true
+	}
+	return nil, false
+}
+
+// reportDrops reports records dropped by the Drop overflow policy.
+func (ls *lanes) reportDrops() {
+	for _, l := range ls.sorted {
+		if atomic.LoadInt64(&l.dropped) == 0 {
+			continue
+		}
+		if n := atomic.SwapInt64(&l.dropped, 0); n > 0 {
+			ls.alert(l.Name, int(n))
+		}
+	}
+}
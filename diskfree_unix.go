@@ -0,0 +1,25 @@
+//go:build linux || darwin
+// +build linux darwin
+
+package filelog
+
+import (
+	"os"
+	"syscall"
+)
+
+func diskUsage(dir string) (free, total uint64, err error) {
+	var st syscall.Statfs_t
+	if err = syscall.Statfs(dir, &st); err != nil {
+		return
+	}
+	free = st.Bavail * uint64(st.Bsize)
+	total = st.Blocks * uint64(st.Bsize)
+	return
+}
+
+// hardLinked reports whether fi has more than one link.
+func hardLinked(fi os.FileInfo) bool {
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	return !ok || st.Nlink > 1
+}
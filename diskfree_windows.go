@@ -0,0 +1,35 @@
+//go:build windows
+// +build windows
+
+package filelog
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+var procGetDiskFreeSpaceEx = syscall.NewLazyDLL("kernel32.dll").NewProc("GetDiskFreeSpaceExW")
+
+func diskUsage(dir string) (free, total uint64, err error) {
+	p, err := syscall.UTF16PtrFromString(dir)
+	if err != nil {
+		return
+	}
+	r, _, e := procGetDiskFreeSpaceEx.Call(
+		uintptr(unsafe.Pointer(p)),
+		uintptr(unsafe.Pointer(&free)),
+		uintptr(unsafe.Pointer(&total)),
+		0,
+	)
+	if r == 0 {
+		err = e
+	}
+	return
+}
+
+// hardLinked reports whether fi may have more than one link, link count
+// isn't available from os.FileInfo on windows so it's always assumed.
+func hardLinked(fi os.FileInfo) bool {
+	return true
+}
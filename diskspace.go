@@ -0,0 +1,68 @@
+package filelog
+
+import (
+	"log"
+	"path/filepath"
+	"sync/atomic"
+)
+
+// MinFreeSpace keep at least bytes free on the log filesystem, oldest log files
+// are deleted first, then records written by Write and lanes with priority <= 0 are dropped
+func MinFreeSpace(bytes uint64) OptionWrapper {
+	return func(o *Option) {
+		o.MinFreeBytes = bytes
+	}
+}
+
+// MinFreePercent same as MinFreeSpace but threshold is percent of the filesystem size
+func MinFreePercent(percent float64) OptionWrapper {
+	return func(o *Option) {
+		o.MinFreePercent = percent
+	}
+}
+
+func (w *fWriter) guardFreeSpace() bool {
+	return w.minFreeBytes > 0 || w.minFreePercent > 0
+}
+
+func (w *fWriter) enoughFreeSpace(dir string) bool {
+	free, total, err := diskUsage(dir)
+	if err != nil {
+		// can't tell, don't drop anything
+		return true
+	}
+	if free < w.minFreeBytes {
+		return false
+	}
+	if w.minFreePercent > 0 && total > 0 && float64(free)*100/float64(total) < w.minFreePercent {
+		return false
+	}
+	return true
+}
+
+// checkFreeSpace deletes oldest log files while the filesystem is low on
+// space, and switches to dropping non priority records if it's still low.
+func (w *fWriter) checkFreeSpace(scan *dirScan) {
+	dir := filepath.Dir(w.filename)
+	if w.enoughFreeSpace(dir) {
+		if atomic.CompareAndSwapInt32(&w.lowSpace, 1, 0) {
+			log.Printf("[filelog] free space of %s recovered, stop dropping records\n", dir)
+		}
+		return
+	}
+	segs := w.scanSegments(scan)
+	for i := len(segs) - 1; i >= 0; i-- {
+		if segs[i].pinned {
+			continue
+		}
+		scan.remove(segs[i].path)
+		log.Printf("[filelog] low free space in %s, remove file %v\n", dir, segs[i].path)
+		if w.enoughFreeSpace(dir) {
+			atomic.CompareAndSwapInt32(&w.lowSpace, 1, 0)
+			return
+		}
+	}
+	if atomic.CompareAndSwapInt32(&w.lowSpace, 0, 1) {
+		log.Printf("[filelog] low free space in %s, drop non priority records\n", dir)
+	}
+}
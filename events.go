@@ -0,0 +1,65 @@
+package filelog
+
+import (
+	"time"
+)
+
+// EventType type of writer event
+type EventType int
+
+const (
+	// EventWriteFailed write to log file failed
+	EventWriteFailed EventType = iota
+	// EventBuffering log file is unhealthy, records are kept in memory
+	EventBuffering
+	// EventFallback memory buffer is full, records go to the fallback writer
+	EventFallback
+	// EventRecovered log file is healthy again and buffered records are replayed
+	EventRecovered
+	// EventShortcutFailed shortcut of the current segment can't be updated
+	EventShortcutFailed
+)
+
+func (t EventType) String() string {
+	switch t {
+	case EventWriteFailed:
+		return "write_failed"
+	case EventBuffering:
+		return "buffering"
+	case EventFallback:
+		return "fallback"
+	case EventRecovered:
+		return "recovered"
+	case EventShortcutFailed:
+		return "shortcut_failed"
+	default:
+		return "unknown"
+	}
+}
+
+// Event is emitted on state transitions of the writer
+type Event struct {
+	Type     EventType
+	Filename string
+	Err      error
+	Time     time.Time
+}
+
+// OnEvent register event handler, handler is called in the writing goroutine so it should return fast
+func OnEvent(fn func(Event)) OptionWrapper {
+	return func(o *Option) {
+		o.OnEvent = fn
+	}
+}
+
+func (w *fWriter) emit(t EventType, err error) {
+	if w.onEvent == nil {
+		return
+	}
+	w.onEvent(Event{
+		Type:     t,
+		Filename: w.realFilename,
+		Err:      err,
+		Time:     time.Now(),
+	})
+}
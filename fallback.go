@@ -0,0 +1,167 @@
+package filelog
+
+import (
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// FallbackBuffer keep at most size bytes of records in memory while log file is unwritable
+func FallbackBuffer(size int64) OptionWrapper {
+	return func(o *Option) {
+		o.FallbackBufferSize = size
+	}
+}
+
+// FallbackWriter write records to w(e.g. os.Stderr) when log file is unwritable and memory buffer is full
+func FallbackWriter(w io.Writer) OptionWrapper {
+	return func(o *Option) {
+		o.FallbackWriter = w
+	}
+}
+
+// FallbackDir write records to a file with the same name in dir(e.g. /dev/shm) when log file is unwritable and memory buffer is full
+func FallbackDir(dir string) OptionWrapper {
+	return func(o *Option) {
+		o.FallbackDir = dir
+	}
+}
+
+// ProbeInterval how often the unwritable log file is probed, default 5s
+func ProbeInterval(d time.Duration) OptionWrapper {
+	return func(o *Option) {
+		o.ProbeInterval = d
+	}
+}
+
+// fallback keeps records while the log file is unwritable, it's only
+// touched by the writing goroutine.
+type fallback struct {
+	bufLimit      int64
+	buf           []byte
+	records       int
+	writer        io.Writer
+	dir           string
+	dirFile       *os.File
+	probeInterval time.Duration
+	degraded      bool
+	overflowed    bool
+	nextProbe     time.Time
+}
+
+func newFallback(opt *Option) *fallback {
+	if opt.FallbackBufferSize <= 0 && opt.FallbackWriter == nil && opt.FallbackDir == "" {
+		return nil
+	}
+	return &fallback{
+		bufLimit:      opt.FallbackBufferSize,
+		writer:        opt.FallbackWriter,
+		dir:           opt.FallbackDir,
+		probeInterval: opt.ProbeInterval,
+	}
+}
+
+// degrade switches to fallback after a failed write of buf.
+func (w *fWriter) degrade(buf []byte, records int, err error) {
+	fb := w.fallback
+	fb.degraded = true
+	fb.nextProbe = time.Now().Add(fb.probeInterval)
+	w.emit(EventBuffering, err)
+	w.stash(buf, records)
+}
+
+// stash keeps buf in memory, or hands it to the fallback writer once the
+// memory buffer is full.
+func (w *fWriter) stash(buf []byte, records int) {
+	fb := w.fallback
+	if int64(len(fb.buf)+len(buf)) <= fb.bufLimit {
+		fb.buf = append(fb.buf, buf...)
+		fb.records += records
+		return
+	}
+	if !fb.overflowed {
+		fb.overflowed = true
+		w.emit(EventFallback, nil)
+	}
+	out := fb.writer
+	if w.crypt != nil {
+		// never leak plain text of encrypted logs
+		out = nil
+	} else if fb.dir != "" {
+		if fb.dirFile == nil {
+			w.perm.mkdir(fb.dir)
+			fd, err := w.perm.openFile(filepath.Join(fb.dir, filepath.Base(w.realFilename)), os.O_WRONLY|os.O_APPEND)
+			if err != nil {
+				log.Printf("[filelog] open fallback file in %s fail %v\n", fb.dir, err)
+			} else {
+				fb.dirFile = fd
+			}
+		}
+		if fb.dirFile != nil {
+			out = fb.dirFile
+		}
+	}
+	if out == nil {
+		log.Printf("[filelog] %d bytes dropped, fallback buffer is full\n", len(buf))
+		return
+	}
+	if _, err := out.Write(buf); err != nil {
+		log.Printf("[filelog] %d bytes dropped, fallback write fail %v\n", len(buf), err)
+	}
+}
+
+// probe reopens the log file and replays buffered records, it returns true
+// once the log file is healthy again.
+func (w *fWriter) probe(force bool) bool {
+	fb := w.fallback
+	if !force && time.Now().Before(fb.nextProbe) {
+		return false
+	}
+	fb.nextProbe = time.Now().Add(fb.probeInterval)
+	if err := w.doRotate(); err != nil {
+		return false
+	}
+	if w.audit != nil {
+		w.audit.rewind()
+	}
+	var out []byte
+	records := fb.records
+	if w.stats.size == 0 {
+		// a fresh segment starts with its headers before the replayed records
+		batch, pending := w.batchBuf, w.pendingRecords
+		w.batchBuf, w.pendingRecords = nil, 0
+		w.appendSegmentStart()
+		out, records = w.batchBuf, records+w.pendingRecords
+		w.batchBuf, w.pendingRecords = batch, pending
+	}
+	if w.audit != nil {
+		// records buffered while unhealthy were chained before the headers
+		out = w.audit.reframe(out, fb.buf)
+	} else {
+		out = append(out, fb.buf...)
+	}
+	if len(out) > 0 {
+		if err := w.writeSegment(out); err != nil {
+			if w.audit != nil {
+				w.audit.rewind()
+			}
+			return false
+		}
+	}
+	if w.audit != nil {
+		w.audit.commit()
+	}
+	w.stats.addRecords(records)
+	fb.buf = nil
+	fb.records = 0
+	fb.degraded = false
+	fb.overflowed = false
+	if fb.dirFile != nil {
+		fb.dirFile.Close()
+		fb.dirFile = nil
+	}
+	w.emit(EventRecovered, nil)
+	return true
+}
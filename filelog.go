@@ -1,15 +1,16 @@
 package filelog
 
 import (
+	"errors"
 	"fmt"
-	"io/ioutil"
+	"io"
 	"log"
 	"os"
 	"path/filepath"
-	"sort"
 	"strings"
 	"sync"
 	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/qjpcpu/filelog/diode"
@@ -18,19 +19,94 @@ import (
 // FileLogWriter log writer
 type FileLogWriter interface {
 	Write(p []byte) (int, error)
+	WriteLane(lane string, p []byte) (int, error)
 	Filename() string
-	Truncate()
+	SetOptions(wrappers ...OptionWrapper) error
+	TransformStats() []TransformStat
+	Truncate(scopes ...TruncateScope) error
 	Close() error
 }
 
 type fileLogWriter struct {
 	*diode.Writer
-	fwriter *fWriter
+	fwriter  *fWriter
+	priority map[string]int
+	lowDrops int64
+	limiter  *limiter
+	optMu    sync.Mutex
+	opt      Option
 }
 
-// Truncate file
-func (fw *fileLogWriter) Truncate() {
-	fw.fwriter.Truncate()
+func (fw *fileLogWriter) Write(p []byte) (int, error) {
+	return fw.WriteLane(diode.DefaultLane, p)
+}
+
+// WriteLane drops records of lanes with priority <= 0 when log filesystem is
+// low on space, rate limiting and sampling also apply to those lanes only
+func (fw *fileLogWriter) WriteLane(lane string, p []byte) (int, error) {
+	if atomic.LoadInt32(&fw.fwriter.lowSpace) == 1 && fw.priority[lane] <= 0 {
+		if atomic.AddInt64(&fw.lowDrops, 1)%1000 == 1 {
+			log.Printf("[filelog] low free space, %d logs dropped\n", atomic.LoadInt64(&fw.lowDrops))
+		}
+		return len(p), nil
+	}
+	if fw.limiter != nil && fw.priority[lane] <= 0 && !fw.limiter.allow(p) {
+		return len(p), nil
+	}
+	return fw.Writer.WriteLane(lane, p)
+}
+
+// SetOptions change RotateBy, Keep, KeepMaxSize and FlushInterval at runtime,
+// changes are applied by the writing goroutine after records already written,
+// other options are ignored
+func (fw *fileLogWriter) SetOptions(wrappers ...OptionWrapper) error {
+	fw.optMu.Lock()
+	defer fw.optMu.Unlock()
+	opt := fw.opt
+	for _, fn := range wrappers {
+		fn(&opt)
+	}
+	if err := opt.validate(); err != nil {
+		return err
+	}
+	w := fw.fwriter
+	err := fw.Writer.Do(func() {
+		w.rt = opt.RotateType
+		w.releaseShortcut()
+		w.keepCount = opt.KeepCount
+		atomic.StoreInt64(&w.maxKeepSize, opt.MaxSize)
+		fw.Writer.SetPollInterval(opt.FlushInterval)
+	})
+	if err != nil {
+		return err
+	}
+	fw.opt = opt
+	w.triggerCleanup()
+	return nil
+}
+
+// TransformStats counters of every transformer
+func (fw *fileLogWriter) TransformStats() []TransformStat {
+	return fw.fwriter.transformStats()
+}
+
+// Truncate empties the current segment, or every segment with TruncateAll.
+// It returns once done, records written before the call are truncated and
+// records written after it are kept.
+func (fw *fileLogWriter) Truncate(scopes ...TruncateScope) error {
+	scope := TruncateCurrent
+	for _, s := range scopes {
+		if s > scope {
+			scope = s
+		}
+	}
+	var err error
+	if derr := fw.Writer.Do(func() {
+		err = fw.fwriter.truncate(scope)
+	}); derr != nil {
+		return derr
+	}
+	return err
 }
 
 func (fw *fileLogWriter) Close() error {
@@ -45,16 +121,51 @@ type fWriter struct {
 	rt             RotateType
 	realFilename   string
 	createShortcut bool
+	shortcut       string
+	shortcutMode   ShortcutMode
 	// flags
 	reOpen        int32
 	nonLinuxWatch int32
-	truncateFlag  int32
 	keepCount     int
 	maxKeepSize   int64
 	closeCh       chan struct{}
 	closeOnce     sync.Once
 	watchOnce     sync.Once
 	disableWatch  bool
+	// record
+	ensureNewline bool
+	maxRecordSize int
+	writeRetries  int
+	batchBuf      []byte
+	batchErr      error
+	onEvent       func(Event)
+	fallback      *fallback
+	// disk
+	current        atomic.Value
+	minFreeBytes   uint64
+	minFreePercent float64
+	lowSpace       int32
+	quota          *QuotaGroup
+	// retention
+	cleanupInterval time.Duration
+	cleanupCh       chan struct{}
+	archivePattern  string
+	uploader        *uploader
+	// segment stats
+	stats          segmentStats
+	pendingRecords int
+	manifest       *manifest
+	audit          *auditState
+	prevSegment    string
+	crypt          *cryptState
+	cryptBuf       []byte
+	transforms     []*transformStage
+	dedup          *dedup
+	headerFn       func(SegmentHeaderInfo) []byte
+	footerFn       func(SegmentFooterInfo) []byte
+	perm           fsPerm
+	ringSize       int64
+	ring           *ringFile
 }
 
 type RotateType int
@@ -67,6 +178,31 @@ const (
 	RotateNone
 )
 
+var rotateNames = map[RotateType]string{
+	RotateDaily:  "daily",
+	RotateMinute: "minute",
+	RotateHourly: "hourly",
+	RotateWeekly: "weekly",
+	RotateNone:   "none",
+}
+
+func (t RotateType) String() string {
+	if name, ok := rotateNames[t]; ok {
+		return name
+	}
+	return fmt.Sprintf("RotateType(%d)", int(t))
+}
+
+// ParseRotateType parse rotate type names: daily, minute, hourly, weekly, none
+func ParseRotateType(name string) (RotateType, error) {
+	for t, n := range rotateNames {
+		if n == strings.ToLower(name) {
+			return t, nil
+		}
+	}
+	return RotateNone, fmt.Errorf("unknown rotate type %q", name)
+}
+
 const (
 	K = 1024
 	M = 1024 * 1024
@@ -81,8 +217,70 @@ type Option struct {
 	KeepCount      int
 	MaxSize        int64
 	DisableWatch   bool
+	EnsureNewline  bool
+	MaxRecordSize  int
+	WriteRetries   int
+	BatchSize      int
+	LowLatency     bool
+	Overflow       Overflow
+	Lanes          []diode.Lane
+	OnEvent        func(Event)
+	// fallback
+	FallbackBufferSize int64
+	FallbackWriter     io.Writer
+	FallbackDir        string
+	ProbeInterval      time.Duration
+	// free space
+	MinFreeBytes   uint64
+	MinFreePercent float64
+	QuotaGroup     *QuotaGroup
+	// CleanupInterval interval of KeepMaxSize cleanup, it also runs after each rotation
+	CleanupInterval time.Duration
+	ArchiveDir      string
+	Uploader        Uploader
+	Manifest        bool
+	ManifestPath    string
+	Audit           bool
+	// encryption
+	KeyProvider      KeyProvider
+	EncryptChunkSize int
+	Transforms       []Transformer
+	// rate limit & sampling
+	RateLimit        float64
+	RateBurst        int
+	SampleFirst      int
+	SampleThereafter int
+	SampleKey        func([]byte) string
+	SummaryInterval  time.Duration
+	// dedup
+	DedupWindow    time.Duration
+	DedupNormalize func([]byte) []byte
+	// segment header & footer
+	SegmentHeader func(SegmentHeaderInfo) []byte
+	SegmentFooter func(SegmentFooterInfo) []byte
+	// permission & ownership
+	FileMode os.FileMode
+	DirMode  os.FileMode
+	Uid      int
+	Gid      int
+	MkdirAll bool
+	// shortcut
+	ShortcutMode ShortcutMode
+	ShortcutName string
+	// RingSize capacity of ring file, 0 disables ring file mode
+	RingSize int64
 }
 
+// Overflow decides what a lane does with a record when it is full
+type Overflow = diode.OverflowPolicy
+
+const (
+	// OverflowBlock waits for free space, which is the default
+	OverflowBlock = diode.Block
+	// OverflowDrop drops the record and reports it
+	OverflowDrop = diode.Drop
+)
+
 type OptionWrapper func(*Option)
 
 func RotateBy(t RotateType) OptionWrapper {
@@ -115,6 +313,75 @@ func KeepMaxSize(size int64) OptionWrapper {
 	}
 }
 
+// BufferSize max records buffered before written, must be 2^n, default 1024
+func BufferSize(size uint64) OptionWrapper {
+	return func(o *Option) {
+		o.BufferSize = size
+	}
+}
+
+// FlushInterval how often the writing goroutine polls buffered records, default 10ms
+func FlushInterval(d time.Duration) OptionWrapper {
+	return func(o *Option) {
+		o.FlushInterval = d
+	}
+}
+
+// EnsureNewline append a trailing newline to records which don't end with one
+func EnsureNewline() OptionWrapper {
+	return func(o *Option) {
+		o.EnsureNewline = true
+	}
+}
+
+// MaxRecordSize cut records longer than size bytes and mark them with a truncated marker
+func MaxRecordSize(size int) OptionWrapper {
+	return func(o *Option) {
+		o.MaxRecordSize = size
+	}
+}
+
+// WriteRetries retry times for transient write errors(EINTR, EAGAIN, ENOSPC), default 3
+func WriteRetries(times int) OptionWrapper {
+	return func(o *Option) {
+		o.WriteRetries = times
+	}
+}
+
+// BatchSize max records coalesced into one write call, default 128
+func BatchSize(size int) OptionWrapper {
+	return func(o *Option) {
+		o.BatchSize = size
+	}
+}
+
+// LowLatency wake the writing goroutine on every write instead of polling every FlushInterval
+func LowLatency() OptionWrapper {
+	return func(o *Option) {
+		o.LowLatency = true
+	}
+}
+
+// DropOnOverflow drop records written by Write instead of blocking when buffer is full
+func DropOnOverflow() OptionWrapper {
+	return func(o *Option) {
+		o.Overflow = OverflowDrop
+	}
+}
+
+// PriorityLane add a lane written by WriteLane, lanes with higher priority are
+// written first, the lane of Write has priority 0, size must be 2^n
+func PriorityLane(name string, priority int, size uint64, overflow Overflow) OptionWrapper {
+	return func(o *Option) {
+		o.Lanes = append(o.Lanes, diode.Lane{
+			Name:     name,
+			Priority: priority,
+			Size:     int(size),
+			Overflow: overflow,
+		})
+	}
+}
+
 // NewWriter create file logger, rotate none & by default
 func NewWriter(filename string, wrappers ...OptionWrapper) (FileLogWriter, error) {
 	f, err := filepath.Abs(filename)
@@ -122,10 +389,20 @@ func NewWriter(filename string, wrappers ...OptionWrapper) (FileLogWriter, error
 		return nil, err
 	}
 	opt := &Option{
-		RotateType:     RotateNone,
-		FlushInterval:  10 * time.Millisecond,
-		BufferSize:     1024,
-		CreateShortcut: false,
+		RotateType:       RotateNone,
+		FlushInterval:    10 * time.Millisecond,
+		BufferSize:       1024,
+		CreateShortcut:   false,
+		WriteRetries:     3,
+		BatchSize:        128,
+		ProbeInterval:    5 * time.Second,
+		CleanupInterval:  time.Hour,
+		EncryptChunkSize: 64 * K,
+		SummaryInterval:  time.Minute,
+		FileMode:         0644,
+		DirMode:          0755,
+		Uid:              -1,
+		Gid:              -1,
 	}
 	for _, fn := range wrappers {
 		fn(opt)
@@ -134,30 +411,118 @@ func NewWriter(filename string, wrappers ...OptionWrapper) (FileLogWriter, error
 		return nil, err
 	}
 	w := &fWriter{
-		filename:       f,
-		rt:             opt.RotateType,
-		createShortcut: opt.CreateShortcut,
-		reOpen:         1,
-		keepCount:      opt.KeepCount,
-		maxKeepSize:    opt.MaxSize,
-		closeCh:        make(chan struct{}, 1),
-		disableWatch:   opt.DisableWatch,
+		filename:        f,
+		rt:              opt.RotateType,
+		createShortcut:  opt.CreateShortcut,
+		reOpen:          1,
+		keepCount:       opt.KeepCount,
+		maxKeepSize:     opt.MaxSize,
+		closeCh:         make(chan struct{}, 1),
+		disableWatch:    opt.DisableWatch,
+		ensureNewline:   opt.EnsureNewline || opt.RingSize > 0,
+		maxRecordSize:   opt.MaxRecordSize,
+		writeRetries:    opt.WriteRetries,
+		onEvent:         opt.OnEvent,
+		fallback:        newFallback(opt),
+		minFreeBytes:    opt.MinFreeBytes,
+		minFreePercent:  opt.MinFreePercent,
+		quota:           opt.QuotaGroup,
+		cleanupInterval: opt.CleanupInterval,
+		cleanupCh:       make(chan struct{}, 1),
+		archivePattern:  resolveArchivePattern(f, opt.ArchiveDir),
+		headerFn:        opt.SegmentHeader,
+		footerFn:        opt.SegmentFooter,
+		perm:            newFsPerm(opt),
+		shortcutMode:    opt.ShortcutMode,
+		shortcut:        resolveShortcut(f, opt.ShortcutName),
+		ringSize:        opt.RingSize,
+	}
+	w.current.Store(logFilename(f, opt.RotateType, time.Now()))
+	if opt.Audit {
+		w.audit = &auditState{}
+	}
+	for _, t := range opt.Transforms {
+		w.transforms = append(w.transforms, &transformStage{t: t})
+	}
+	if opt.DedupWindow > 0 {
+		w.dedup = newDedup(opt.DedupWindow, opt.DedupNormalize)
+	}
+	if opt.KeyProvider != nil {
+		w.crypt = &cryptState{kp: opt.KeyProvider, chunkSize: opt.EncryptChunkSize}
+	}
+	if opt.Manifest {
+		w.manifest = newManifest(f, opt.ManifestPath, w.perm)
+	}
+	if opt.Uploader != nil {
+		w.uploader = newUploader(opt.Uploader, f, w.manifest, w.perm)
+	}
+	dopts := []diode.Option{
+		diode.WithBatchSize(opt.BatchSize),
+		diode.WithOverflow(opt.Overflow),
+		diode.WithLaneAlerter(func(lane string, dropped int) {
+			log.Printf("[filelog] %d logs dropped in lane %s\n", dropped, lane)
+		}),
+	}
+	for _, l := range opt.Lanes {
+		dopts = append(dopts, diode.WithLane(l))
+	}
+	if opt.LowLatency {
+		dopts = append(dopts, diode.WithWaiter())
 	}
 	wr := diode.NewWriter(w, int(opt.BufferSize), opt.FlushInterval, func(dropped int) {
 		log.Printf("[filelog] %d logs dropped\n", dropped)
-	})
+	}, dopts...)
 	fw := &fileLogWriter{
-		Writer:  &wr,
-		fwriter: w,
+		Writer:   &wr,
+		fwriter:  w,
+		priority: make(map[string]int),
+		limiter:  newLimiter(opt),
+		opt:      *opt,
+	}
+	for _, l := range opt.Lanes {
+		fw.priority[l.Name] = l.Priority
+	}
+	if w.quota != nil {
+		w.quota.join(w)
+	}
+	if fw.limiter != nil {
+		go fw.reportSuppressed()
+	}
+	if w.dedup != nil {
+		go fw.expireDedup()
+	}
+	w.startUpload()
+	if w.quota == nil {
+		go w.secureDiskPressure()
 	}
-	go fw.fwriter.secureDiskPressure()
 	return fw, nil
 }
 
 func (w *fWriter) Close() (err error) {
 	w.closeOnce.Do(func() {
+		if w.quota != nil {
+			w.quota.leave(w)
+		}
+		if w.dedup != nil {
+			if summary := w.dedup.flush(); summary != nil {
+				w.push(summary)
+				w.flushBatch()
+			}
+		}
+		w.writeFooter(CloseWriter)
+		if w.fallback != nil && w.fallback.degraded && !w.probe(true) && len(w.fallback.buf) > 0 {
+			log.Printf("[filelog] %d buffered bytes lost on close\n", len(w.fallback.buf))
+		}
+		if w.fallback != nil && w.fallback.dirFile != nil {
+			w.fallback.dirFile.Close()
+		}
 		if w.file != nil {
+			w.sealSegment()
 			err = w.file.Close()
+			w.recordSegment(w.realFilename)
+		}
+		if w.uploader != nil {
+			w.uploader.close()
 		}
 		close(w.closeCh)
 	})
@@ -171,6 +536,63 @@ func (opt *Option) validate() error {
 	if opt.FlushInterval <= 0 {
 		return fmt.Errorf("flush interval not set")
 	}
+	if opt.MaxRecordSize < 0 {
+		return fmt.Errorf("max record size %d < 0", opt.MaxRecordSize)
+	}
+	if opt.MaxRecordSize > 0 && opt.MaxRecordSize <= len(truncatedMarker)+1 {
+		return fmt.Errorf("max record size %d too small", opt.MaxRecordSize)
+	}
+	names := map[string]bool{diode.DefaultLane: true}
+	for _, l := range opt.Lanes {
+		if l.Name == "" || names[l.Name] {
+			return fmt.Errorf("lane name %q empty or duplicated", l.Name)
+		}
+		names[l.Name] = true
+		if !is2n(uint64(l.Size)) {
+			return fmt.Errorf("lane %s size %d != 2^n", l.Name, l.Size)
+		}
+	}
+	if opt.BatchSize <= 0 {
+		return fmt.Errorf("batch size %d <= 0", opt.BatchSize)
+	}
+	if opt.KeyProvider != nil && (opt.EncryptChunkSize <= 0 || opt.EncryptChunkSize > 16*M) {
+		return fmt.Errorf("encrypt chunk size %d out of range (0, 16M]", opt.EncryptChunkSize)
+	}
+	if opt.RateLimit > 0 && opt.RateBurst < 1 {
+		return fmt.Errorf("rate burst %d < 1", opt.RateBurst)
+	}
+	if opt.SampleFirst < 0 || opt.SampleThereafter < 0 {
+		return fmt.Errorf("sample first %d, thereafter %d must not be negative", opt.SampleFirst, opt.SampleThereafter)
+	}
+	if (opt.RateLimit > 0 || opt.SampleFirst > 0) && opt.SummaryInterval <= 0 {
+		return fmt.Errorf("summary interval not set")
+	}
+	if opt.CleanupInterval <= 0 {
+		return fmt.Errorf("cleanup interval not set")
+	}
+	if opt.MinFreePercent < 0 || opt.MinFreePercent >= 100 {
+		return fmt.Errorf("min free percent %v out of range [0, 100)", opt.MinFreePercent)
+	}
+	if opt.FallbackBufferSize < 0 {
+		return fmt.Errorf("fallback buffer size %d < 0", opt.FallbackBufferSize)
+	}
+	if opt.ProbeInterval <= 0 {
+		return fmt.Errorf("probe interval not set")
+	}
+	if opt.WriteRetries < 0 {
+		return fmt.Errorf("write retries %d < 0", opt.WriteRetries)
+	}
+	if opt.RingSize < 0 {
+		return fmt.Errorf("ring size %d < 0", opt.RingSize)
+	}
+	if opt.RingSize > 0 {
+		switch {
+		case opt.RotateType != RotateNone:
+			return fmt.Errorf("ring file doesn't rotate, use RotateNone")
+		case opt.Audit, opt.KeyProvider != nil, opt.Manifest:
+			return fmt.Errorf("ring file doesn't support audit, encryption or manifest")
+		}
+	}
 	return nil
 }
 
@@ -203,20 +625,42 @@ func (w *fileLogWriter) Filename() string { return w.fwriter.realFilename }
 
 func (w *fWriter) openFile() error {
 	// Open the log file
+	w.releaseShortcut()
 	w.realFilename = logFilename(w.filename, w.rt, time.Now())
-	fd, err := os.OpenFile(w.realFilename, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	flag := os.O_WRONLY | os.O_APPEND
+	if w.ringSize > 0 {
+		flag = os.O_RDWR
+	}
+	fd, err := w.perm.openFile(w.realFilename, flag)
 	if err != nil {
 		return err
 	}
+	if w.ringSize > 0 {
+		if w.ring, err = openRing(fd, w.ringSize); err != nil {
+			fd.Close()
+			return err
+		}
+	}
 	atomic.StoreInt32(&w.reOpen, 0)
 	w.file = fd
-	if w.createShortcut && w.rt != RotateNone {
-		linkto, _ := os.Readlink(w.filename)
-		if linkto == "" || filepath.Base(linkto) != filepath.Base(w.realFilename) {
-			os.Remove(w.filename)
-			os.Symlink(filepath.Base(w.realFilename), w.filename)
+	w.current.Store(w.realFilename)
+	w.loadStats()
+	if w.crypt != nil && w.stats.size > 0 {
+		end, err := w.crypt.resume(w.realFilename)
+		if err != nil {
+			log.Printf("[filelog] resume encrypted segment %s fail %v\n", w.realFilename, err)
+		} else if end < w.stats.size {
+			// cut the final chunk of a sealed segment, it's written again on
+			// close, or a partial chunk left by a crash
+			if err := fd.Truncate(end); err != nil {
+				log.Printf("[filelog] unseal encrypted segment %s fail %v\n", w.realFilename, err)
+				w.crypt.aead = nil
+			}
+			w.loadStats()
 		}
 	}
+	w.loadAudit()
+	w.updateShortcut()
 	if !w.disableWatch {
 		w.watchOnce.Do(func() {
 			w.watchFile()
@@ -229,101 +673,266 @@ func (w *fWriter) removeOldFile() {
 	if w.keepCount <= 0 || w.rt == RotateNone {
 		return
 	}
+	var old string
 	switch w.rt {
 	case RotateDaily:
-		os.Remove(logFilename(w.filename, w.rt, time.Now().AddDate(0, 0, -1*w.keepCount)))
+		old = logFilename(w.filename, w.rt, time.Now().AddDate(0, 0, -1*w.keepCount))
 	case RotateHourly:
-		os.Remove(logFilename(w.filename, w.rt, time.Now().Add(-time.Hour*time.Duration(w.keepCount))))
+		old = logFilename(w.filename, w.rt, time.Now().Add(-time.Hour*time.Duration(w.keepCount)))
 	case RotateMinute:
-		os.Remove(logFilename(w.filename, w.rt, time.Now().Add(-time.Minute*time.Duration(w.keepCount))))
+		old = logFilename(w.filename, w.rt, time.Now().Add(-time.Minute*time.Duration(w.keepCount)))
 	case RotateWeekly:
-		os.Remove(logFilename(w.filename, w.rt, time.Now().AddDate(0, 0, -7*w.keepCount)))
+		old = logFilename(w.filename, w.rt, time.Now().AddDate(0, 0, -7*w.keepCount))
+	default:
+		return
+	}
+	if !w.removable(old) {
+		return
+	}
+	os.Remove(old)
+	if w.archivePattern != "" {
+		os.Remove(w.archivePath(old))
 	}
 }
 
 func (w *fWriter) doRotate() error {
 	// Close any log file that may be open
+	w.sealSegment()
 	fd := w.file
 	if fd != nil {
 		fd.Close()
 		w.file = nil
 	}
+	if prev := w.realFilename; prev != "" && prev != logFilename(w.filename, w.rt, time.Now()) {
+		w.prevSegment = w.archive(prev)
+		w.segmentClosed(w.prevSegment)
+	}
 	// Open the log file
 	return w.openFile()
 }
 
+// segmentClosed is called with the final path of a rotated segment
+func (w *fWriter) segmentClosed(path string) {
+	w.recordSegment(path)
+	if w.uploader != nil {
+		w.uploader.enqueue(path)
+	}
+}
+
 func (w *fWriter) needRotate() bool {
 	return w.realFilename != logFilename(w.filename, w.rt, time.Now()) || w.reOpen == 1
 }
 
-func (w *fWriter) Truncate() {
-	atomic.CompareAndSwapInt32(&w.truncateFlag, 0, 1)
+// Write writes p as one record
+func (w *fWriter) Write(p []byte) (int, error) {
+	if err := w.WriteBatch([][]byte{p}); err != nil {
+		return 0, err
+	}
+	return len(p), nil
 }
 
-func (w *fWriter) Write(p []byte) (int, error) {
-	if w.needRotate() {
-		if err := w.doRotate(); err != nil {
-			fmt.Fprintf(os.Stderr, "fWriter(%q): %s\n", w.filename, err)
+// WriteBatch coalesces records into one buffer and writes it with a single
+// write call, rotation is checked before every record so that a record is
+// never split across segments.
+func (w *fWriter) WriteBatch(records [][]byte) error {
+	w.batchErr = nil
+	for _, p := range records {
+		if len(w.transforms) > 0 {
+			var keep bool
+			if p, keep = w.transform(p); !keep {
+				continue
+			}
+		}
+		if w.dedup != nil {
+			summary, keep := w.dedup.check(p, time.Now())
+			if summary != nil {
+				w.push(summary)
+			}
+			if !keep {
+				continue
+			}
 		}
-		w.removeOldFile()
+		w.push(p)
+	}
+	w.flushBatch()
+	if cap(w.batchBuf) > 2*maxBatchBytes {
+		w.batchBuf = nil
+	}
+	return w.batchErr
+}
+
+const maxBatchBytes = 256 * K
+
+// push appends record p to the pending batch.
+func (w *fWriter) push(p []byte) {
+	if w.needRotate() {
+		w.rotate()
+	}
+	w.appendRecord(p)
+	if len(w.batchBuf) >= maxBatchBytes {
+		w.flushBatch()
 	}
-	if w.truncateFlag == 1 && atomic.CompareAndSwapInt32(&w.truncateFlag, 1, 0) {
-		w.file.Truncate(0)
-		w.file.Seek(0, 0)
+}
+
+// rotate switches to the segment of now, or reopens a deleted one.
+func (w *fWriter) rotate() error {
+	if w.reOpen == 0 {
+		w.writeFooter(CloseRotate)
 	}
-	// Perform the write
-	n, err := w.file.Write(p)
+	w.flushBatch()
+	err := w.doRotate()
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "fWriter(%q): %s\n", w.filename, err)
 	}
-	return n, err
+	w.removeOldFile()
+	w.triggerCleanup()
+	w.appendSegmentStart()
+	return err
 }
 
-func (w *fWriter) secureDiskPressure() {
-	if w.maxKeepSize <= 0 {
+// appendRecord appends p to the pending batch without rotation checks.
+func (w *fWriter) appendRecord(p []byte) {
+	if w.audit != nil {
+		w.batchBuf = w.audit.appendFrame(w.batchBuf, w.normalizeRecord(p))
+	} else {
+		w.batchBuf = append(w.batchBuf, w.normalizeRecord(p)...)
+	}
+	w.pendingRecords++
+}
+
+// appendSegmentStart appends leading records of an empty segment.
+func (w *fWriter) appendSegmentStart() {
+	if w.file == nil || w.stats.size > 0 {
 		return
 	}
-	ticker := time.NewTicker(time.Hour * 1)
-	defer ticker.Stop()
-	for {
-		select {
-		case <-ticker.C:
-			w.removeLargeLogs()
-		case <-w.closeCh:
-			return
+	if w.audit != nil {
+		w.batchBuf = w.appendAuditHeader(w.batchBuf)
+	}
+	w.appendHeader()
+}
+
+// flushBatch writes the pending batch, the first error is kept in batchErr.
+func (w *fWriter) flushBatch() {
+	if err := w.writeBatchBuf(w.batchBuf); err != nil && w.batchErr == nil {
+		w.batchErr = err
+	}
+	w.batchBuf = w.batchBuf[:0]
+}
+
+func (w *fWriter) writeBatchBuf(buf []byte) error {
+	if len(buf) == 0 {
+		return nil
+	}
+	records := w.pendingRecords
+	w.pendingRecords = 0
+	if w.fallback != nil && w.fallback.degraded {
+		if !w.probe(false) {
+			w.stash(buf, records)
+			return nil
+		}
+		if w.audit != nil {
+			buf = w.audit.reframe(nil, buf)
 		}
 	}
+	if err := w.writeSegment(buf); err != nil {
+		w.emit(EventWriteFailed, err)
+		if w.fallback != nil {
+			w.degrade(buf, records, err)
+			return nil
+		}
+		fmt.Fprintf(os.Stderr, "fWriter(%q): %s\n", w.filename, err)
+		return err
+	}
+	if w.audit != nil {
+		w.audit.commit()
+	}
+	w.stats.addRecords(records)
+	return nil
 }
 
-func (w *fWriter) removeLargeLogs() {
-	dir := filepath.Dir(w.realFilename)
-	base := filepath.Base(w.filename)
-	fileInfos, err := ioutil.ReadDir(dir)
+// writeSegment writes plain text p to the current segment, encrypted if enabled
+func (w *fWriter) writeSegment(p []byte) error {
+	if w.ring != nil {
+		if err := w.ring.write(p); err != nil {
+			return err
+		}
+		w.stats.written(p)
+		return nil
+	}
+	if w.crypt == nil {
+		_, err := w.writeFull(p)
+		return err
+	}
+	out, next, err := w.crypt.seal(w.cryptBuf[:0], p, w.stats.size == 0)
 	if err != nil {
-		log.Printf("[filelog] read dir %s file %v\n", dir, err)
-		return
+		return err
+	}
+	if cap(out) <= 2*maxBatchBytes {
+		w.cryptBuf = out[:0]
 	}
-	var files []string
-	for _, fi := range fileInfos {
-		if strings.HasPrefix(fi.Name(), base+".") {
-			files = append(files, filepath.Join(dir, fi.Name()))
+	if _, err = w.writeFull(out); err != nil {
+		return err
+	}
+	w.crypt.index = next
+	return nil
+}
+
+const truncatedMarker = "...[truncated]"
+
+func (w *fWriter) normalizeRecord(p []byte) []byte {
+	if w.maxRecordSize > 0 && len(p) > w.maxRecordSize {
+		// keep the terminator so the next record starts on its own line
+		newline := w.ensureNewline || p[len(p)-1] == '\n'
+		limit := w.maxRecordSize - len(truncatedMarker)
+		if newline {
+			limit--
+		}
+		cut := make([]byte, 0, w.maxRecordSize)
+		cut = append(cut, p[:limit]...)
+		cut = append(cut, truncatedMarker...)
+		if newline {
+			cut = append(cut, '\n')
 		}
+		p = cut
 	}
-	sort.SliceStable(files, func(i, j int) bool {
-		return files[i] > files[j]
-	})
-	var acc int64
-	for i, file := range files {
-		if fi, err := os.Stat(file); err == nil {
-			acc += fi.Size()
+	if w.ensureNewline && (len(p) == 0 || p[len(p)-1] != '\n') {
+		p = append(p[:len(p):len(p)], '\n')
+	}
+	return p
+}
+
+// writeFull writes the whole p or nothing, short writes are continued and
+// transient errors are retried, a partially written record is rolled back.
+func (w *fWriter) writeFull(p []byte) (int, error) {
+	var written, retry int
+	for written < len(p) {
+		n, err := w.file.Write(p[written:])
+		written += n
+		if err == nil {
+			continue
 		}
-		if i == 0 {
+		if retry < w.writeRetries && isTransientError(err) {
+			retry++
+			time.Sleep(time.Duration(retry) * 10 * time.Millisecond)
 			continue
 		}
-		if acc > w.maxKeepSize {
-			os.Truncate(file, 0)
-			os.RemoveAll(file)
-			log.Printf("[filelog] accumulate size %v > %v, truncate file %v\n", acc, w.maxKeepSize, file)
+		if written > 0 {
+			if fi, serr := w.file.Stat(); serr == nil {
+				w.file.Truncate(fi.Size() - int64(written))
+			}
 		}
+		return 0, err
 	}
+	w.stats.written(p)
+	return written, nil
+}
+
+func isTransientError(err error) bool {
+	return errors.Is(err, syscall.EINTR) || errors.Is(err, syscall.EAGAIN) || errors.Is(err, syscall.ENOSPC)
+}
+
+// currentFile is the segment being written, safe to call from any goroutine
+func (w *fWriter) currentFile() string {
+	name, _ := w.current.Load().(string)
+	return name
 }
@@ -0,0 +1,55 @@
+package filelog
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var benchRecord = []byte(`{"level":"info","msg":"request served","status":200,"latency_ms":3}` + "\n")
+
+func benchmarkWriter(b *testing.B, wrappers ...OptionWrapper) {
+	dir, err := ioutil.TempDir("", "filelog")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	w, err := NewWriter(filepath.Join(dir, "bench.log"), wrappers...)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.SetBytes(int64(len(benchRecord)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		w.Write(benchRecord)
+	}
+	w.Close()
+}
+
+// BenchmarkWriterPerRecord writes every record with its own write call like
+// the writer did before batching.
+func BenchmarkWriterPerRecord(b *testing.B) {
+	benchmarkWriter(b, BatchSize(1))
+}
+
+// BenchmarkWriterBatched coalesces up to 128 records per write call.
+func BenchmarkWriterBatched(b *testing.B) {
+	benchmarkWriter(b)
+}
+
+func TestNormalizeRecordKeepsNewlineOfCutRecord(t *testing.T) {
+	w := &fWriter{maxRecordSize: 20}
+	long := strings.Repeat("x", 30)
+	for record, expect := range map[string]string{
+		long + "\n": "xxxxx" + truncatedMarker + "\n",
+		long:        "xxxxxx" + truncatedMarker,
+		"short\n":   "short\n",
+	} {
+		if got := string(w.normalizeRecord([]byte(record))); got != expect {
+			t.Fatalf("%q: got %q, expect %q", record, got, expect)
+		}
+	}
+}
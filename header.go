@@ -0,0 +1,95 @@
+package filelog
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Segment close reasons of SegmentFooterInfo
+const (
+	CloseRotate = "rotate"
+	CloseWriter = "close"
+)
+
+// SegmentHeaderInfo is passed to the header callback when a segment is created
+type SegmentHeaderInfo struct {
+	Segment     string
+	PrevSegment string
+	Hostname    string
+	Pid         int
+	Time        time.Time
+}
+
+// SegmentFooterInfo is passed to the footer callback when a segment is closed
+type SegmentFooterInfo struct {
+	Segment string
+	Records int64
+	Bytes   int64
+	Reason  string
+	Time    time.Time
+}
+
+// SegmentHeader write the record returned by fn at the beginning of every new segment
+func SegmentHeader(fn func(SegmentHeaderInfo) []byte) OptionWrapper {
+	return func(o *Option) {
+		o.SegmentHeader = fn
+	}
+}
+
+// SegmentFooter write the record returned by fn at the end of every closed segment
+func SegmentFooter(fn func(SegmentFooterInfo) []byte) OptionWrapper {
+	return func(o *Option) {
+		o.SegmentFooter = fn
+	}
+}
+
+// DefaultSegmentHeader header with host, pid, build version, schema and previous segment
+func DefaultSegmentHeader(version, schema string) func(SegmentHeaderInfo) []byte {
+	return func(h SegmentHeaderInfo) []byte {
+		return []byte(fmt.Sprintf("# segment=%s prev=%s host=%s pid=%d version=%s schema=%s time=%s\n",
+			h.Segment, h.PrevSegment, h.Hostname, h.Pid, version, schema, h.Time.Format(time.RFC3339)))
+	}
+}
+
+// DefaultSegmentFooter footer with record count, byte count and close reason
+func DefaultSegmentFooter(f SegmentFooterInfo) []byte {
+	return []byte(fmt.Sprintf("# segment=%s records=%d bytes=%d reason=%s time=%s\n",
+		f.Segment, f.Records, f.Bytes, f.Reason, f.Time.Format(time.RFC3339)))
+}
+
+var hostname, _ = os.Hostname()
+
+func (w *fWriter) appendHeader() {
+	if w.headerFn == nil {
+		return
+	}
+	prev := ""
+	if w.prevSegment != "" {
+		prev = filepath.Base(w.prevSegment)
+	}
+	w.appendRecord(w.headerFn(SegmentHeaderInfo{
+		Segment:     filepath.Base(w.realFilename),
+		PrevSegment: prev,
+		Hostname:    hostname,
+		Pid:         os.Getpid(),
+		Time:        time.Now(),
+	}))
+}
+
+// writeFooter writes the footer of the current segment right away.
+func (w *fWriter) writeFooter(reason string) {
+	if w.footerFn == nil || w.file == nil {
+		return
+	}
+	w.flushBatch()
+	w.appendRecord(w.footerFn(SegmentFooterInfo{
+		Segment: filepath.Base(w.realFilename),
+		Records: w.stats.records,
+		Bytes:   w.stats.size,
+		Reason:  reason,
+		Time:    time.Now(),
+	}))
+	w.flushBatch()
+}
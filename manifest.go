@@ -0,0 +1,255 @@
+package filelog
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"hash"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SegmentManifest maintain a JSON lines manifest of all segments at path,
+// empty path means the log filename with a .manifest suffix
+func SegmentManifest(path string) OptionWrapper {
+	return func(o *Option) {
+		o.Manifest = true
+		o.ManifestPath = path
+	}
+}
+
+// SegmentInfo is one entry of the segment manifest
+type SegmentInfo struct {
+	Name        string    `json:"name"`
+	Path        string    `json:"path"`
+	FirstRecord time.Time `json:"first_record"`
+	LastRecord  time.Time `json:"last_record"`
+	Size        int64     `json:"size"`
+	Records     int64     `json:"records"`
+	SHA256      string    `json:"sha256"`
+	Compressed  bool      `json:"compressed"`
+	Uploaded    bool      `json:"uploaded"`
+}
+
+// Covers tells whether the segment has records within [from, to]
+func (si SegmentInfo) Covers(from, to time.Time) bool {
+	return !si.LastRecord.Before(from) && !si.FirstRecord.After(to)
+}
+
+// ReadManifest read the segment manifest, later entries of the same segment win
+func ReadManifest(path string) ([]SegmentInfo, error) {
+	fd, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer fd.Close()
+	byName := make(map[string]int)
+	var infos []SegmentInfo
+	scanner := bufio.NewScanner(fd)
+	for scanner.Scan() {
+		var si SegmentInfo
+		if err := json.Unmarshal(scanner.Bytes(), &si); err != nil || si.Name == "" {
+			continue
+		}
+		if i, ok := byName[si.Name]; ok {
+			infos[i] = si
+		} else {
+			byName[si.Name] = len(infos)
+			infos = append(infos, si)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	sort.SliceStable(infos, func(i, j int) bool {
+		return infos[i].FirstRecord.Before(infos[j].FirstRecord)
+	})
+	return infos, nil
+}
+
+// SegmentsCovering returns segments having records within [from, to]
+func SegmentsCovering(infos []SegmentInfo, from, to time.Time) []SegmentInfo {
+	var res []SegmentInfo
+	for _, si := range infos {
+		if si.Covers(from, to) {
+			res = append(res, si)
+		}
+	}
+	return res
+}
+
+// segmentStats tracks the open segment, only touched by the writing goroutine.
+type segmentStats struct {
+	path    string
+	first   time.Time
+	last    time.Time
+	size    int64
+	records int64
+	hash    hash.Hash
+}
+
+func (st *segmentStats) reset(withHash bool) {
+	*st = segmentStats{}
+	if withHash {
+		st.hash = sha256.New()
+	}
+}
+
+func (st *segmentStats) written(p []byte) {
+	st.size += int64(len(p))
+	if st.hash != nil {
+		st.hash.Write(p)
+	}
+}
+
+func (st *segmentStats) addRecords(n int) {
+	if n <= 0 {
+		return
+	}
+	now := time.Now()
+	if st.first.IsZero() {
+		st.first = now
+	}
+	st.last = now
+	st.records += int64(n)
+}
+
+// loadStats accounts content already in the segment when it's reopened.
+func (w *fWriter) loadStats() {
+	prev := w.stats
+	w.stats.reset(w.manifest != nil)
+	w.stats.path = w.realFilename
+	fd, err := os.Open(w.realFilename)
+	if err != nil {
+		return
+	}
+	defer fd.Close()
+	fi, err := fd.Stat()
+	if err != nil || fi.Size() == 0 {
+		return
+	}
+	if w.stats.hash == nil {
+		w.stats.size = fi.Size()
+		return
+	}
+	buf := make([]byte, 32*K)
+	for {
+		n, err := fd.Read(buf)
+		w.stats.written(buf[:n])
+		if w.audit == nil && w.crypt == nil {
+			w.stats.records += int64(bytes.Count(buf[:n], []byte{'\n'}))
+		}
+		if err != nil {
+			break
+		}
+	}
+	w.stats.first = fi.ModTime()
+	w.stats.last = fi.ModTime()
+	// mtime is the time of the last write, stats of the segment before it's
+	// reopened by probe or the manifest entry written when it was closed
+	// know when it began
+	if prev.path != w.realFilename || prev.first.IsZero() {
+		si, ok := w.manifest.lookup(filepath.Base(w.realFilename))
+		if !ok || si.FirstRecord.IsZero() {
+			return
+		}
+		prev.first, prev.records = si.FirstRecord, si.Records
+	}
+	if prev.first.Before(w.stats.first) {
+		w.stats.first = prev.first
+	}
+	if w.audit != nil || w.crypt != nil {
+		w.stats.records = prev.records
+	}
+}
+
+type manifest struct {
+	path string
+	perm fsPerm
+	mu   sync.Mutex
+	last map[string]SegmentInfo
+}
+
+func newManifest(filename, path string, perm fsPerm) *manifest {
+	if path == "" {
+		path = filename + ".manifest"
+	}
+	m := &manifest{path: path, perm: perm, last: make(map[string]SegmentInfo)}
+	if infos, err := ReadManifest(path); err == nil {
+		for _, si := range infos {
+			m.last[si.Name] = si
+		}
+	}
+	return m
+}
+
+func (m *manifest) append(si SegmentInfo) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.last[si.Name] = si
+	line, _ := json.Marshal(si)
+	fd, err := m.perm.openFile(m.path, os.O_WRONLY|os.O_APPEND)
+	if err != nil {
+		log.Printf("[filelog] write manifest %s fail %v\n", m.path, err)
+		return
+	}
+	defer fd.Close()
+	fd.Write(append(line, '\n'))
+}
+
+// lookup returns the latest entry of the segment named name.
+func (m *manifest) lookup(name string) (SegmentInfo, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	si, ok := m.last[name]
+	return si, ok
+}
+
+// markUploaded records the upload state of a segment already in the manifest.
+func (m *manifest) markUploaded(path string) {
+	si, ok := m.lookup(filepath.Base(path))
+	if !ok {
+		return
+	}
+	si.Uploaded = true
+	m.append(si)
+}
+
+// recordSegment writes the manifest entry of the segment closed at path.
+func (w *fWriter) recordSegment(path string) {
+	if w.manifest == nil || path == "" {
+		return
+	}
+	si := SegmentInfo{
+		Name:        filepath.Base(path),
+		Path:        path,
+		FirstRecord: w.stats.first,
+		LastRecord:  w.stats.last,
+		Size:        w.stats.size,
+		Records:     w.stats.records,
+		Compressed:  isCompressed(path),
+	}
+	if w.stats.hash != nil {
+		si.SHA256 = hex.EncodeToString(w.stats.hash.Sum(nil))
+	}
+	if w.uploader != nil {
+		si.Uploaded = w.uploader.isShipped(path)
+	}
+	w.manifest.append(si)
+}
+
+func isCompressed(path string) bool {
+	for _, ext := range compressedExts {
+		if strings.HasSuffix(path, ext) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,46 @@
+package filelog
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestManifestKeepsFirstRecordOnReopen(t *testing.T) {
+	for name, wrappers := range map[string][]OptionWrapper{
+		"plain":   nil,
+		"encrypt": {Encrypt(testKey)},
+	} {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "app.log")
+			opts := append([]OptionWrapper{SegmentManifest("")}, wrappers...)
+			begin := time.Now()
+			w, err := NewWriter(path, append(opts, DisableWatchFile())...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			w.Write([]byte("a\n"))
+			time.Sleep(50 * time.Millisecond)
+			w.Write([]byte("b\n"))
+			w.Close()
+			writeRecords(t, path, []string{"c\n"}, opts...)
+			infos, err := ReadManifest(path + ".manifest")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(infos) != 1 {
+				t.Fatalf("expect 1 segment, got %+v", infos)
+			}
+			si := infos[0]
+			if si.FirstRecord.After(begin.Add(25 * time.Millisecond)) {
+				t.Fatalf("first record %v is after the first run began at %v", si.FirstRecord, begin)
+			}
+			if si.Records != 3 {
+				t.Fatalf("expect 3 records, got %d", si.Records)
+			}
+			if got := SegmentsCovering(infos, begin, begin.Add(10*time.Millisecond)); len(got) != 1 {
+				t.Fatalf("segment doesn't cover the first run")
+			}
+		})
+	}
+}
@@ -0,0 +1,114 @@
+package filelog
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// FileMode permission of created log files, default 0644
+func FileMode(mode os.FileMode) OptionWrapper {
+	return func(o *Option) {
+		o.FileMode = mode
+	}
+}
+
+// DirMode permission of created directories, default 0755
+func DirMode(mode os.FileMode) OptionWrapper {
+	return func(o *Option) {
+		o.DirMode = mode
+	}
+}
+
+// Chown change owner of created files, directories and the shortcut, -1 keeps
+// the current uid or gid. It's useful for services which drop privileges.
+func Chown(uid, gid int) OptionWrapper {
+	return func(o *Option) {
+		o.Uid, o.Gid = uid, gid
+	}
+}
+
+// MkdirAll create missing parent directories of the log file
+func MkdirAll() OptionWrapper {
+	return func(o *Option) {
+		o.MkdirAll = true
+	}
+}
+
+// fsPerm how files and directories are created
+type fsPerm struct {
+	fileMode os.FileMode
+	dirMode  os.FileMode
+	uid, gid int
+	mkdirAll bool
+}
+
+func newFsPerm(opt *Option) fsPerm {
+	return fsPerm{
+		fileMode: opt.FileMode,
+		dirMode:  opt.DirMode,
+		uid:      opt.Uid,
+		gid:      opt.Gid,
+		mkdirAll: opt.MkdirAll,
+	}
+}
+
+// openFile opens name with access flag like os.O_WRONLY|os.O_APPEND, a
+// created file gets the exact file mode regardless of umask and the
+// configured owner.
+func (p fsPerm) openFile(name string, flag int) (*os.File, error) {
+	if p.mkdirAll {
+		if err := p.mkdir(filepath.Dir(name)); err != nil {
+			return nil, err
+		}
+	}
+	fd, err := os.OpenFile(name, flag|os.O_CREATE|os.O_EXCL, p.fileMode)
+	if os.IsExist(err) {
+		return os.OpenFile(name, flag, p.fileMode)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := fd.Chmod(p.fileMode); err != nil {
+		log.Printf("[filelog] chmod %s fail %v\n", name, err)
+	}
+	p.chown(name)
+	return fd, nil
+}
+
+// mkdir creates dir and missing parents with dir mode and owner.
+func (p fsPerm) mkdir(dir string) error {
+	var created []string
+	for d := dir; ; d = filepath.Dir(d) {
+		if _, err := os.Stat(d); err == nil || filepath.Dir(d) == d {
+			break
+		}
+		created = append(created, d)
+	}
+	if err := os.MkdirAll(dir, p.dirMode); err != nil {
+		return err
+	}
+	for _, d := range created {
+		os.Chmod(d, p.dirMode)
+		p.chown(d)
+	}
+	return nil
+}
+
+func (p fsPerm) chown(name string) {
+	if p.uid < 0 && p.gid < 0 {
+		return
+	}
+	if err := os.Chown(name, p.uid, p.gid); err != nil {
+		log.Printf("[filelog] chown %s fail %v\n", name, err)
+	}
+}
+
+func (p fsPerm) lchown(name string) {
+	if p.uid < 0 && p.gid < 0 {
+		return
+	}
+	if err := os.Lchown(name, p.uid, p.gid); err != nil {
+		log.Printf("[filelog] chown %s fail %v\n", name, err)
+	}
+}
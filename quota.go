@@ -0,0 +1,157 @@
+package filelog
+
+import (
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+// QuotaGroup enforces one byte budget over log files of all member writers,
+// oldest files are removed first by a single scanning goroutine. The goroutine
+// also enforces KeepMaxSize and MinFreeSpace of members, every directory is
+// read once per pass however many members write into it.
+type QuotaGroup struct {
+	maxSize   int64
+	interval  time.Duration
+	mu        sync.Mutex
+	members   map[*fWriter]struct{}
+	cleanupCh chan struct{}
+	closeCh   chan struct{}
+	closeOnce sync.Once
+}
+
+// NewQuotaGroup create a quota group of maxSize bytes checked every interval
+func NewQuotaGroup(maxSize int64, interval time.Duration) *QuotaGroup {
+	if interval <= 0 {
+		interval = time.Minute
+	}
+	g := &QuotaGroup{
+		maxSize:   maxSize,
+		interval:  interval,
+		members:   make(map[*fWriter]struct{}),
+		cleanupCh: make(chan struct{}, 1),
+		closeCh:   make(chan struct{}),
+	}
+	go g.run()
+	return g
+}
+
+// JoinQuota add writer to quota group g, writer leaves the group when closed,
+// its KeepMaxSize is enforced by the group every group interval instead of
+// CleanupInterval
+func JoinQuota(g *QuotaGroup) OptionWrapper {
+	return func(o *Option) {
+		o.QuotaGroup = g
+	}
+}
+
+// Close stops the scanning goroutine, retention of writers still in the group
+// is no longer enforced
+func (g *QuotaGroup) Close() {
+	g.closeOnce.Do(func() {
+		close(g.closeCh)
+	})
+}
+
+func (g *QuotaGroup) join(w *fWriter) {
+	g.mu.Lock()
+	g.members[w] = struct{}{}
+	g.mu.Unlock()
+	g.trigger()
+}
+
+func (g *QuotaGroup) leave(w *fWriter) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	delete(g.members, w)
+}
+
+// trigger asks for a cleanup pass, e.g. after a member rotated.
+func (g *QuotaGroup) trigger() {
+	select {
+	case g.cleanupCh <- struct{}{}:
+	default:
+	}
+}
+
+func (g *QuotaGroup) run() {
+	ticker := time.NewTicker(g.interval)
+	defer ticker.Stop()
+	spaceTicker := time.NewTicker(10 * time.Second)
+	defer spaceTicker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			g.enforce()
+		case <-g.cleanupCh:
+			g.enforce()
+		case <-spaceTicker.C:
+			g.checkFreeSpace()
+		case <-g.closeCh:
+			return
+		}
+	}
+}
+
+func (g *QuotaGroup) snapshot() []*fWriter {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	members := make([]*fWriter, 0, len(g.members))
+	for w := range g.members {
+		members = append(members, w)
+	}
+	return members
+}
+
+func (g *QuotaGroup) checkFreeSpace() {
+	scan := newDirScan()
+	for _, w := range g.snapshot() {
+		if w.guardFreeSpace() {
+			w.checkFreeSpace(scan)
+		}
+	}
+}
+
+// enforce runs retention of every member then the group budget in one pass.
+func (g *QuotaGroup) enforce() {
+	members := g.snapshot()
+	scan := newDirScan()
+	for _, w := range members {
+		w.removeLargeLogs(scan)
+		if w.guardFreeSpace() {
+			w.checkFreeSpace(scan)
+		}
+	}
+	var files []segment
+	var total int64
+	seen := make(map[string]bool)
+	for _, w := range members {
+		// members in the same directory share one read of it
+		for _, seg := range w.scanSegments(scan) {
+			if seen[seg.path] {
+				continue
+			}
+			seen[seg.path] = true
+			total += seg.size
+			files = append(files, seg)
+		}
+	}
+	if total <= g.maxSize {
+		return
+	}
+	sort.SliceStable(files, func(i, j int) bool {
+		return files[i].ts.Before(files[j].ts)
+	})
+	for _, f := range files {
+		if total <= g.maxSize {
+			return
+		}
+		if f.pinned {
+			continue
+		}
+		scan.remove(f.path)
+		total -= f.size
+		log.Printf("[filelog] quota group size exceed %v, remove file %v\n", g.maxSize, f.path)
+	}
+}
@@ -0,0 +1,58 @@
+package filelog
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestQuotaGroupRemovesOldestAcrossMembers(t *testing.T) {
+	dir := t.TempDir()
+	g := NewQuotaGroup(300, time.Hour)
+	defer g.Close()
+	var members []*fWriter
+	for _, name := range []string{"a.log", "b.log"} {
+		w, err := NewWriter(filepath.Join(dir, name), RotateBy(RotateDaily), JoinQuota(g), DisableWatchFile())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer w.Close()
+		members = append(members, w.(*fileLogWriter).fwriter)
+	}
+	for name, size := range map[string]int{
+		"a.log.2020-01-01": 200,
+		"b.log.2020-01-02": 200,
+		"a.log.2020-01-03": 100,
+	} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), bytes.Repeat([]byte("x"), size), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	g.enforce()
+	if _, err := os.Stat(filepath.Join(dir, "a.log.2020-01-01")); !os.IsNotExist(err) {
+		t.Fatalf("oldest segment is kept: %v", err)
+	}
+	for _, name := range []string{"b.log.2020-01-02", "a.log.2020-01-03"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// members in one directory share a single read of it per pass
+	scan := newDirScan()
+	before := len(members[0].scanSegments(scan))
+	if err := ioutil.WriteFile(filepath.Join(dir, "a.log.2020-01-04"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	members[1].scanSegments(scan)
+	if got := len(members[0].scanSegments(scan)); got != before {
+		t.Fatalf("directory read again during the pass, %d segments instead of %d", got, before)
+	}
+	scan.remove(filepath.Join(dir, "a.log.2020-01-03"))
+	if got := len(members[0].scanSegments(scan)); got != before-1 {
+		t.Fatalf("removed segment still listed, %d segments", got)
+	}
+}
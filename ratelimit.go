@@ -0,0 +1,191 @@
+package filelog
+
+import (
+	"fmt"
+	"hash/fnv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// RateLimit allow perSecond records with burst by token bucket, records of
+// lanes with priority > 0 are never limited
+func RateLimit(perSecond float64, burst int) OptionWrapper {
+	return func(o *Option) {
+		o.RateLimit = perSecond
+		o.RateBurst = burst
+	}
+}
+
+// Sample allow first records per key every second, then every thereafter-th
+// record, records of lanes with priority > 0 are never sampled
+func Sample(first, thereafter int) OptionWrapper {
+	return func(o *Option) {
+		o.SampleFirst = first
+		o.SampleThereafter = thereafter
+	}
+}
+
+// SampleKey derive the sampling key of a record, default key is the first 32 bytes of the record
+func SampleKey(fn func(record []byte) string) OptionWrapper {
+	return func(o *Option) {
+		o.SampleKey = fn
+	}
+}
+
+// SummaryInterval how often suppressed records are summarized into the log, default 1 minute
+func SummaryInterval(d time.Duration) OptionWrapper {
+	return func(o *Option) {
+		o.SummaryInterval = d
+	}
+}
+
+const (
+	sampleBuckets   = 4096
+	samplePrefixLen = 32
+)
+
+type tokenBucket struct {
+	mu         sync.Mutex
+	rate       float64
+	burst      float64
+	tokens     float64
+	last       time.Time
+	suppressed int64
+}
+
+func (tb *tokenBucket) allow() bool {
+	tb.mu.Lock()
+	now := time.Now()
+	tb.tokens += now.Sub(tb.last).Seconds() * tb.rate
+	if tb.tokens > tb.burst {
+		tb.tokens = tb.burst
+	}
+	tb.last = now
+	ok := tb.tokens >= 1
+	if ok {
+		tb.tokens--
+	}
+	tb.mu.Unlock()
+	if !ok {
+		atomic.AddInt64(&tb.suppressed, 1)
+	}
+	return ok
+}
+
+type sampleBucket struct {
+	tick       int64
+	count      uint64
+	suppressed int64
+	key        atomic.Value
+}
+
+type sampler struct {
+	first      uint64
+	thereafter uint64
+	keyFn      func([]byte) string
+	buckets    [sampleBuckets]sampleBucket
+}
+
+func (s *sampler) key(p []byte) string {
+	if s.keyFn != nil {
+		return s.keyFn(p)
+	}
+	if len(p) > samplePrefixLen {
+		p = p[:samplePrefixLen]
+	}
+	return string(p)
+}
+
+func (s *sampler) allow(p []byte) bool {
+	key := s.key(p)
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	b := &s.buckets[h.Sum32()%sampleBuckets]
+	tick := time.Now().Unix()
+	if old := atomic.LoadInt64(&b.tick); old != tick && atomic.CompareAndSwapInt64(&b.tick, old, tick) {
+		atomic.StoreUint64(&b.count, 0)
+	}
+	n := atomic.AddUint64(&b.count, 1)
+	if n <= s.first || (s.thereafter > 0 && (n-s.first)%s.thereafter == 0) {
+		return true
+	}
+	if atomic.AddInt64(&b.suppressed, 1) == 1 {
+		b.key.Store(key)
+	}
+	return false
+}
+
+// limiter sits in front of the diode, it's called by producers concurrently.
+type limiter struct {
+	bucket   *tokenBucket
+	sampler  *sampler
+	interval time.Duration
+}
+
+func newLimiter(opt *Option) *limiter {
+	if opt.RateLimit <= 0 && opt.SampleFirst <= 0 {
+		return nil
+	}
+	l := &limiter{interval: opt.SummaryInterval}
+	if opt.RateLimit > 0 {
+		l.bucket = &tokenBucket{
+			rate:   opt.RateLimit,
+			burst:  float64(opt.RateBurst),
+			tokens: float64(opt.RateBurst),
+			last:   time.Now(),
+		}
+	}
+	if opt.SampleFirst > 0 {
+		l.sampler = &sampler{
+			first:      uint64(opt.SampleFirst),
+			thereafter: uint64(opt.SampleThereafter),
+			keyFn:      opt.SampleKey,
+		}
+	}
+	return l
+}
+
+func (l *limiter) allow(p []byte) bool {
+	if l.sampler != nil && !l.sampler.allow(p) {
+		return false
+	}
+	return l.bucket == nil || l.bucket.allow()
+}
+
+// summaries drains suppression counters into summary lines.
+func (l *limiter) summaries() []string {
+	var lines []string
+	if l.sampler != nil {
+		for i := range l.sampler.buckets {
+			b := &l.sampler.buckets[i]
+			if atomic.LoadInt64(&b.suppressed) == 0 {
+				continue
+			}
+			n := atomic.SwapInt64(&b.suppressed, 0)
+			key, _ := b.key.Load().(string)
+			lines = append(lines, fmt.Sprintf("[filelog] suppressed %d records matching key %q\n", n, key))
+		}
+	}
+	if l.bucket != nil {
+		if n := atomic.SwapInt64(&l.bucket.suppressed, 0); n > 0 {
+			lines = append(lines, fmt.Sprintf("[filelog] rate limited %d records\n", n))
+		}
+	}
+	return lines
+}
+
+func (fw *fileLogWriter) reportSuppressed() {
+	ticker := time.NewTicker(fw.limiter.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			for _, line := range fw.limiter.summaries() {
+				fw.Writer.Write([]byte(line))
+			}
+		case <-fw.fwriter.closeCh:
+			return
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package filelog
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sync"
+)
+
+var registry = struct {
+	sync.Mutex
+	writers map[string]*sharedWriter
+}{writers: make(map[string]*sharedWriter)}
+
+type sharedWriter struct {
+	FileLogWriter
+	path string
+	refs int
+}
+
+// registeredWriter is one reference of a shared writer, Close releases it
+type registeredWriter struct {
+	*sharedWriter
+	once sync.Once
+}
+
+// Open return the process wide writer of path, creating it with wrappers on
+// first use. Writers are deduped by absolute path and reference counted: the
+// file is closed when every writer returned by Open or Get has been closed.
+// Wrappers of later calls for an opened path are ignored.
+func Open(path string, wrappers ...OptionWrapper) (FileLogWriter, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	registry.Lock()
+	defer registry.Unlock()
+	if sw, ok := registry.writers[abs]; ok {
+		sw.refs++
+		return &registeredWriter{sharedWriter: sw}, nil
+	}
+	w, err := NewWriter(abs, wrappers...)
+	if err != nil {
+		return nil, err
+	}
+	sw := &sharedWriter{FileLogWriter: w, path: abs, refs: 1}
+	registry.writers[abs] = sw
+	return &registeredWriter{sharedWriter: sw}, nil
+}
+
+// Get return a new reference of the writer opened for name, which is the path
+// given to Open
+func Get(name string) (FileLogWriter, bool) {
+	abs, err := filepath.Abs(name)
+	if err != nil {
+		return nil, false
+	}
+	registry.Lock()
+	defer registry.Unlock()
+	sw, ok := registry.writers[abs]
+	if !ok {
+		return nil, false
+	}
+	sw.refs++
+	return &registeredWriter{sharedWriter: sw}, true
+}
+
+// Close release this reference, the file is closed with the last one
+func (rw *registeredWriter) Close() (err error) {
+	rw.once.Do(func() {
+		registry.Lock()
+		rw.refs--
+		last := rw.refs == 0 && registry.writers[rw.path] == rw.sharedWriter
+		if last {
+			delete(registry.writers, rw.path)
+		}
+		registry.Unlock()
+		if last {
+			err = rw.FileLogWriter.Close()
+		}
+	})
+	return
+}
+
+// CloseAll flush and close every registered writer regardless of references,
+// it returns ctx.Err() if ctx is done before all writers are closed
+func CloseAll(ctx context.Context) error {
+	registry.Lock()
+	writers := make([]*sharedWriter, 0, len(registry.writers))
+	for path, sw := range registry.writers {
+		writers = append(writers, sw)
+		delete(registry.writers, path)
+	}
+	registry.Unlock()
+
+	errCh := make(chan error, len(writers))
+	for _, sw := range writers {
+		go func(sw *sharedWriter) {
+			if err := sw.FileLogWriter.Close(); err != nil {
+				errCh <- fmt.Errorf("close %s: %v", sw.path, err)
+				return
+			}
+			errCh <- nil
+		}(sw)
+	}
+	var firstErr error
+	for range writers {
+		select {
+		case err := <-errCh:
+			if err != nil && firstErr == nil {
+				firstErr = err
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return firstErr
+}
@@ -0,0 +1,97 @@
+package filelog
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// reloadConfig is the JSON document read by WatchConfig, e.g.
+//
+//	{"rotate": "hourly", "keep": 24, "keep_max_size": "1GB", "flush_interval": "10ms"}
+//
+// fields use the same names and formats as Config.
+type reloadConfig struct {
+	RotateType    string    `json:"rotate"`
+	Keep          *int      `json:"keep"`
+	KeepMaxSize   *ByteSize `json:"keep_max_size"`
+	FlushInterval *Duration `json:"flush_interval"`
+}
+
+func (rc *reloadConfig) wrappers() ([]OptionWrapper, error) {
+	var wrappers []OptionWrapper
+	if rc.RotateType != "" {
+		rt, err := ParseRotateType(rc.RotateType)
+		if err != nil {
+			return nil, err
+		}
+		wrappers = append(wrappers, RotateBy(rt))
+	}
+	if rc.Keep != nil {
+		wrappers = append(wrappers, Keep(*rc.Keep))
+	}
+	if rc.KeepMaxSize != nil {
+		wrappers = append(wrappers, KeepMaxSize(int64(*rc.KeepMaxSize)))
+	}
+	if rc.FlushInterval != nil {
+		wrappers = append(wrappers, FlushInterval(time.Duration(*rc.FlushInterval)))
+	}
+	return wrappers, nil
+}
+
+func reloadFile(path string, w FileLogWriter) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var rc reloadConfig
+	if err = json.Unmarshal(data, &rc); err != nil {
+		return fmt.Errorf("parse %s: %v", path, err)
+	}
+	wrappers, err := rc.wrappers()
+	if err != nil {
+		return fmt.Errorf("parse %s: %v", path, err)
+	}
+	return w.SetOptions(wrappers...)
+}
+
+// WatchConfig apply the JSON config file at path to w now and whenever it
+// changes, the file is checked every interval, call stop to stop watching
+func WatchConfig(path string, w FileLogWriter, interval time.Duration) (stop func(), err error) {
+	if err = reloadFile(path, w); err != nil {
+		return nil, err
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	lastMod, lastSize := fi.ModTime(), fi.Size()
+	ticker := time.NewTicker(interval)
+	stopCh := make(chan struct{})
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				fi, err := os.Stat(path)
+				if err != nil || (fi.ModTime().Equal(lastMod) && fi.Size() == lastSize) {
+					continue
+				}
+				lastMod, lastSize = fi.ModTime(), fi.Size()
+				if err := reloadFile(path, w); err != nil {
+					log.Printf("[filelog] reload config %s fail %v\n", path, err)
+				}
+			case <-stopCh:
+				return
+			}
+		}
+	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() { close(stopCh) })
+	}, nil
+}
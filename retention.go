@@ -0,0 +1,262 @@
+package filelog
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// CleanupInterval how often KeepMaxSize is enforced besides after each rotation, default 1 hour
+func CleanupInterval(d time.Duration) OptionWrapper {
+	return func(o *Option) {
+		o.CleanupInterval = d
+	}
+}
+
+// compressedExts are suffixes of compressed segments, they are stripped
+// before parsing the timestamp of a segment name.
+var compressedExts = []string{".gz", ".zst", ".bz2", ".xz", ".lz4", ".zip"}
+
+// segmentLayouts are the timestamp layouts produced by logFilename.
+var segmentLayouts = []string{
+	"2006-01-02.15.04",
+	"2006-01-02.15",
+	"2006-01-02",
+}
+
+type segment struct {
+	path    string
+	size    int64
+	ts      time.Time
+	current bool
+	// pinned segments must not be removed by retention
+	pinned bool
+}
+
+// segmentTime parses the timestamp encoded in a segment name, ok is false
+// when the name doesn't carry one.
+func segmentTime(base, name string) (ts time.Time, ok bool) {
+	suffix := strings.TrimPrefix(name, base+".")
+	if suffix == name {
+		return
+	}
+	for _, ext := range compressedExts {
+		suffix = strings.TrimSuffix(suffix, ext)
+	}
+	for _, layout := range segmentLayouts {
+		if t, err := time.ParseInLocation(layout, suffix, time.Local); err == nil {
+			return t, true
+		}
+	}
+	return
+}
+
+// listSegments lists log files of this writer including compressed and archived ones,
+// current segment first then newest first, ordered by the timestamp in their
+// names, the unrotated file is ordered by mtime.
+func (w *fWriter) listSegments() []segment {
+	return w.scanSegments(nil)
+}
+
+// scanSegments is listSegments with directories read through scan.
+func (w *fWriter) scanSegments(scan *dirScan) []segment {
+	dir := filepath.Dir(w.filename)
+	base := filepath.Base(w.filename)
+	fileInfos, err := scan.readDir(dir)
+	if err != nil {
+		log.Printf("[filelog] read dir %s file %v\n", dir, err)
+		return nil
+	}
+	current := w.currentFile()
+	curInfo, _ := os.Stat(current)
+	var segs []segment
+	for _, fi := range fileInfos {
+		name := fi.Name()
+		if !fi.Mode().IsRegular() {
+			// skip the shortcut symlink
+			continue
+		}
+		path := filepath.Join(dir, name)
+		if path != current && (path == w.shortcut || (curInfo != nil && os.SameFile(fi, curInfo))) {
+			// skip the hard link shortcut, it is the current segment
+			continue
+		}
+		ts, ok := segmentTime(base, name)
+		if !ok {
+			if name != base {
+				// not a segment of this writer, e.g. app.log.err.2006-01-02
+				continue
+			}
+			ts = fi.ModTime()
+		}
+		segs = append(segs, segment{
+			path:    path,
+			size:    fi.Size(),
+			ts:      ts,
+			current: path == current,
+		})
+	}
+	segs = append(segs, w.listArchived(scan)...)
+	for i := range segs {
+		segs[i].pinned = segs[i].current || !w.removable(segs[i].path)
+	}
+	sort.SliceStable(segs, func(i, j int) bool {
+		if segs[i].current != segs[j].current {
+			return segs[i].current
+		}
+		return segs[i].ts.After(segs[j].ts)
+	})
+	return segs
+}
+
+// removeSegment removes a closed segment. It's truncated first so that space
+// is freed even if the file is still held open, unless it's hard linked,
+// where truncating would empty the other links too.
+func removeSegment(path string) {
+	if fi, err := os.Lstat(path); err == nil && fi.Mode().IsRegular() && !hardLinked(fi) {
+		os.Truncate(path, 0)
+	}
+	os.Remove(path)
+}
+
+// dirScan reads every directory once during a cleanup pass over several
+// writers, files removed in the pass are dropped from it. A nil dirScan
+// reads directories each time.
+type dirScan struct {
+	dirs  map[string][]os.FileInfo
+	trees map[string][]scannedFile
+}
+
+type scannedFile struct {
+	path string
+	fi   os.FileInfo
+}
+
+func newDirScan() *dirScan {
+	return &dirScan{dirs: make(map[string][]os.FileInfo), trees: make(map[string][]scannedFile)}
+}
+
+func (s *dirScan) readDir(dir string) ([]os.FileInfo, error) {
+	if s == nil {
+		return ioutil.ReadDir(dir)
+	}
+	if fis, ok := s.dirs[dir]; ok {
+		return fis, nil
+	}
+	fis, err := ioutil.ReadDir(dir)
+	if err == nil {
+		s.dirs[dir] = fis
+	}
+	return fis, err
+}
+
+// walk lists regular files under root.
+func (s *dirScan) walk(root string) []scannedFile {
+	if s != nil {
+		if files, ok := s.trees[root]; ok {
+			return files
+		}
+	}
+	var files []scannedFile
+	filepath.Walk(root, func(path string, fi os.FileInfo, err error) error {
+		if err == nil && fi.Mode().IsRegular() {
+			files = append(files, scannedFile{path: path, fi: fi})
+		}
+		return nil
+	})
+	if s != nil {
+		s.trees[root] = files
+	}
+	return files
+}
+
+// remove removes segment path and drops it from the scan.
+func (s *dirScan) remove(path string) {
+	removeSegment(path)
+	if s == nil {
+		return
+	}
+	dir, name := filepath.Dir(path), filepath.Base(path)
+	if fis, ok := s.dirs[dir]; ok {
+		kept := fis[:0:0]
+		for _, fi := range fis {
+			if fi.Name() != name {
+				kept = append(kept, fi)
+			}
+		}
+		s.dirs[dir] = kept
+	}
+	for root, files := range s.trees {
+		kept := files[:0:0]
+		for _, f := range files {
+			if f.path != path {
+				kept = append(kept, f)
+			}
+		}
+		s.trees[root] = kept
+	}
+}
+
+func (w *fWriter) triggerCleanup() {
+	if w.quota != nil {
+		w.quota.trigger()
+		return
+	}
+	if atomic.LoadInt64(&w.maxKeepSize) <= 0 {
+		return
+	}
+	select {
+	case w.cleanupCh <- struct{}{}:
+	default:
+	}
+}
+
+// secureDiskPressure runs cleanup of a writer outside of a QuotaGroup, the
+// group runs it for its members.
+func (w *fWriter) secureDiskPressure() {
+	var spaceCh <-chan time.Time
+	sizeTicker := time.NewTicker(w.cleanupInterval)
+	defer sizeTicker.Stop()
+	sizeCh := sizeTicker.C
+	if w.guardFreeSpace() {
+		ticker := time.NewTicker(10 * time.Second)
+		defer ticker.Stop()
+		spaceCh = ticker.C
+		w.checkFreeSpace(nil)
+	}
+	for {
+		select {
+		case <-sizeCh:
+			w.removeLargeLogs(nil)
+		case <-w.cleanupCh:
+			w.removeLargeLogs(nil)
+		case <-spaceCh:
+			w.checkFreeSpace(nil)
+		case <-w.closeCh:
+			return
+		}
+	}
+}
+
+// removeLargeLogs keeps the newest segments within maxKeepSize, the current
+// segment is always kept.
+func (w *fWriter) removeLargeLogs(scan *dirScan) {
+	maxKeepSize := atomic.LoadInt64(&w.maxKeepSize)
+	if maxKeepSize <= 0 {
+		return
+	}
+	var acc int64
+	for _, seg := range w.scanSegments(scan) {
+		acc += seg.size
+		if seg.pinned || acc <= maxKeepSize {
+			continue
+		}
+		scan.remove(seg.path)
+		log.Printf("[filelog] accumulate size %v > %v, truncate file %v\n", acc, maxKeepSize, seg.path)
+	}
+}
@@ -0,0 +1,258 @@
+package filelog
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+)
+
+// RingFile write into a single preallocated file of size bytes where records
+// wrap around, oldest lines are overwritten first. Disk usage is constant and
+// no file is ever rotated or removed. Records are newline delimited, read them
+// back with ReadRingRecords or `filelog ring`.
+func RingFile(size int64) OptionWrapper {
+	return func(o *Option) {
+		o.RingSize = size
+	}
+}
+
+// ring file layout:
+//
+//	header: magic(8) capacity(8) head(8) used(8) crc32(4), padded to ringHeaderSize
+//	data:   capacity bytes, used bytes starting at head, wrapping at the end
+const ringHeaderSize = 64
+
+var ringMagic = []byte("FLRING1\n")
+
+// ErrNotRingFile file is neither empty nor a ring file
+var ErrNotRingFile = errors.New("not a ring file")
+
+type ringFile struct {
+	fd       *os.File
+	capacity int64
+	head     int64
+	used     int64
+}
+
+type ringHeader struct {
+	capacity, head, used int64
+}
+
+func (h ringHeader) marshal() []byte {
+	b := make([]byte, ringHeaderSize)
+	copy(b, ringMagic)
+	binary.BigEndian.PutUint64(b[8:], uint64(h.capacity))
+	binary.BigEndian.PutUint64(b[16:], uint64(h.head))
+	binary.BigEndian.PutUint64(b[24:], uint64(h.used))
+	binary.BigEndian.PutUint32(b[32:], crc32.ChecksumIEEE(b[:32]))
+	return b
+}
+
+func readRingHeader(r io.ReaderAt) (ringHeader, error) {
+	var h ringHeader
+	b := make([]byte, ringHeaderSize)
+	if _, err := r.ReadAt(b, 0); err != nil {
+		return h, ErrNotRingFile
+	}
+	if !bytes.Equal(b[:8], ringMagic) {
+		return h, ErrNotRingFile
+	}
+	if crc32.ChecksumIEEE(b[:32]) != binary.BigEndian.Uint32(b[32:]) {
+		return h, errors.New("ring header checksum mismatch")
+	}
+	h.capacity = int64(binary.BigEndian.Uint64(b[8:]))
+	h.head = int64(binary.BigEndian.Uint64(b[16:]))
+	h.used = int64(binary.BigEndian.Uint64(b[24:]))
+	if h.capacity <= 0 || h.head < 0 || h.head >= h.capacity || h.used < 0 || h.used > h.capacity {
+		return h, errors.New("ring header out of range")
+	}
+	return h, nil
+}
+
+// openRing loads the ring of fd, an empty file or a ring of another capacity
+// is (re)initialized.
+func openRing(fd *os.File, capacity int64) (*ringFile, error) {
+	r := &ringFile{fd: fd, capacity: capacity}
+	fi, err := fd.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if fi.Size() > 0 {
+		h, err := readRingHeader(fd)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", fd.Name(), err)
+		}
+		if h.capacity == capacity && fi.Size() == ringHeaderSize+capacity {
+			r.head, r.used = h.head, h.used
+			return r, nil
+		}
+		log.Printf("[filelog] ring file %s capacity %d != %d, reinitialize\n", fd.Name(), h.capacity, capacity)
+	}
+	return r, r.init()
+}
+
+// init preallocates the file and empties the ring.
+func (r *ringFile) init() error {
+	if err := r.fd.Truncate(0); err != nil {
+		return err
+	}
+	zero := make([]byte, 32*K)
+	for off := int64(0); off < ringHeaderSize+r.capacity; off += int64(len(zero)) {
+		n := ringHeaderSize + r.capacity - off
+		if n > int64(len(zero)) {
+			n = int64(len(zero))
+		}
+		if _, err := r.fd.WriteAt(zero[:n], off); err != nil {
+			return err
+		}
+	}
+	r.head, r.used = 0, 0
+	return r.writeHeader()
+}
+
+func (r *ringFile) writeHeader() error {
+	_, err := r.fd.WriteAt(ringHeader{capacity: r.capacity, head: r.head, used: r.used}.marshal(), 0)
+	return err
+}
+
+// readAt reads data at offset off of the ring, wrapping at the end.
+func (r *ringFile) readAt(b []byte, off int64) error {
+	off %= r.capacity
+	n := int64(len(b))
+	if first := r.capacity - off; n > first {
+		if _, err := r.fd.ReadAt(b[:first], ringHeaderSize+off); err != nil {
+			return err
+		}
+		_, err := r.fd.ReadAt(b[first:], ringHeaderSize)
+		return err
+	}
+	_, err := r.fd.ReadAt(b, ringHeaderSize+off)
+	return err
+}
+
+// writeAt writes data at offset off of the ring, wrapping at the end.
+func (r *ringFile) writeAt(b []byte, off int64) error {
+	off %= r.capacity
+	n := int64(len(b))
+	if first := r.capacity - off; n > first {
+		if _, err := r.fd.WriteAt(b[:first], ringHeaderSize+off); err != nil {
+			return err
+		}
+		_, err := r.fd.WriteAt(b[first:], ringHeaderSize)
+		return err
+	}
+	_, err := r.fd.WriteAt(b, ringHeaderSize+off)
+	return err
+}
+
+// evict drops the oldest whole lines until n bytes are free.
+func (r *ringFile) evict(n int64) error {
+	need := r.used + n - r.capacity
+	if need <= 0 {
+		return nil
+	}
+	buf := make([]byte, 4*K)
+	dropped := need
+	for dropped < r.used {
+		// stop right after a newline
+		var last [1]byte
+		if err := r.readAt(last[:], r.head+dropped-1); err != nil {
+			return err
+		}
+		if last[0] == '\n' {
+			break
+		}
+		chunk := buf
+		if rest := r.used - dropped; rest < int64(len(chunk)) {
+			chunk = chunk[:rest]
+		}
+		if err := r.readAt(chunk, r.head+dropped); err != nil {
+			return err
+		}
+		if i := bytes.IndexByte(chunk, '\n'); i >= 0 {
+			dropped += int64(i) + 1
+			break
+		}
+		dropped += int64(len(chunk))
+	}
+	if dropped > r.used {
+		dropped = r.used
+	}
+	r.head = (r.head + dropped) % r.capacity
+	r.used -= dropped
+	if r.used == 0 {
+		r.head = 0
+	}
+	// commit eviction before the space is overwritten
+	return r.writeHeader()
+}
+
+// write appends p, a p larger than the ring keeps its newest lines only.
+func (r *ringFile) write(p []byte) error {
+	if int64(len(p)) > r.capacity {
+		cut := int64(len(p)) - r.capacity
+		if p[cut-1] != '\n' {
+			if i := bytes.IndexByte(p[cut:len(p)-1], '\n'); i >= 0 {
+				cut += int64(i) + 1
+			}
+		}
+		p = p[cut:]
+	}
+	if err := r.evict(int64(len(p))); err != nil {
+		return err
+	}
+	if err := r.writeAt(p, r.head+r.used); err != nil {
+		return err
+	}
+	r.used += int64(len(p))
+	return r.writeHeader()
+}
+
+// reset empties the ring.
+func (r *ringFile) reset() error {
+	r.head, r.used = 0, 0
+	return r.writeHeader()
+}
+
+// ReadRingRecords calls fn with each record of ring file path from oldest to
+// newest, the trailing newline is kept. Reading a ring which is being written
+// may see a torn record at the head.
+func ReadRingRecords(path string, fn func(record []byte) error) error {
+	fd, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer fd.Close()
+	h, err := readRingHeader(fd)
+	if err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	first := h.used
+	if first > h.capacity-h.head {
+		first = h.capacity - h.head
+	}
+	br := bufio.NewReader(io.MultiReader(
+		io.NewSectionReader(fd, ringHeaderSize+h.head, first),
+		io.NewSectionReader(fd, ringHeaderSize, h.used-first),
+	))
+	for {
+		record, err := br.ReadBytes('\n')
+		if len(record) > 0 {
+			if ferr := fn(record); ferr != nil {
+				return ferr
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package filelog
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func readRing(t *testing.T, path string) []string {
+	t.Helper()
+	var records []string
+	if err := ReadRingRecords(path, func(record []byte) error {
+		records = append(records, string(record))
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	return records
+}
+
+// expectRingTail checks records are the newest ones before next in order.
+func expectRingTail(t *testing.T, records []string, next int) {
+	t.Helper()
+	if len(records) < 7 {
+		t.Fatalf("expect at least 7 records, got %q", records)
+	}
+	for i, r := range records {
+		if expect := fmt.Sprintf("rec-%02d\n", next-len(records)+i); r != expect {
+			t.Fatalf("record %d: got %q, expect %q in %q", i, r, expect, records)
+		}
+	}
+}
+
+func TestRingFileWrapsAndReopens(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.ring")
+	var records []string
+	for i := 0; i < 20; i++ {
+		records = append(records, fmt.Sprintf("rec-%02d\n", i))
+	}
+	writeRecords(t, path, records[:10], RingFile(64))
+	writeRecords(t, path, records[10:], RingFile(64))
+	expectRingTail(t, readRing(t, path), 20)
+
+	writeRecords(t, path, []string{"rec-20\n", "rec-21\n"}, RingFile(64))
+	expectRingTail(t, readRing(t, path), 22)
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Size() != ringHeaderSize+64 {
+		t.Fatalf("ring file grows to %d bytes", fi.Size())
+	}
+}
@@ -0,0 +1,149 @@
+package filelog
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// ShortcutMode how the shortcut points to the current segment
+type ShortcutMode int
+
+const (
+	// ShortcutSymlink relative symlink, which is the default
+	ShortcutSymlink ShortcutMode = iota
+	// ShortcutAbsSymlink symlink with absolute target
+	ShortcutAbsSymlink
+	// ShortcutHardlink hard link for tools that don't follow symlinks
+	ShortcutHardlink
+)
+
+func (m ShortcutMode) String() string {
+	switch m {
+	case ShortcutSymlink:
+		return "symlink"
+	case ShortcutAbsSymlink:
+		return "absolute"
+	case ShortcutHardlink:
+		return "hardlink"
+	default:
+		return "unknown"
+	}
+}
+
+// ParseShortcutMode parse names returned by ShortcutMode.String
+func ParseShortcutMode(s string) (ShortcutMode, error) {
+	for _, m := range []ShortcutMode{ShortcutSymlink, ShortcutAbsSymlink, ShortcutHardlink} {
+		if m.String() == s {
+			return m, nil
+		}
+	}
+	return ShortcutSymlink, fmt.Errorf("unknown shortcut mode %q", s)
+}
+
+// ShortcutLink set how the shortcut is linked, it's replaced atomically in all modes
+func ShortcutLink(mode ShortcutMode) OptionWrapper {
+	return func(o *Option) {
+		o.ShortcutMode = mode
+	}
+}
+
+// ShortcutName create shortcut with another name, relative to the directory
+// of the log file, e.g. "app.current.log". It also works with RotateNone.
+func ShortcutName(name string) OptionWrapper {
+	return func(o *Option) {
+		o.CreateShortcut = true
+		o.ShortcutName = name
+	}
+}
+
+func resolveShortcut(filename, name string) string {
+	if name == "" {
+		return filename
+	}
+	if !filepath.IsAbs(name) {
+		name = filepath.Join(filepath.Dir(filename), name)
+	}
+	return filepath.Clean(name)
+}
+
+// updateShortcut points the shortcut to the current segment.
+func (w *fWriter) updateShortcut() {
+	if !w.createShortcut || w.shortcut == w.realFilename {
+		return
+	}
+	if err := linkShortcut(w.shortcutMode, w.realFilename, w.shortcut); err != nil {
+		log.Printf("[filelog] update shortcut %s fail %v\n", w.shortcut, err)
+		w.emit(EventShortcutFailed, err)
+		return
+	}
+	w.perm.lchown(w.shortcut)
+}
+
+// releaseShortcut removes a shortcut left at the path of the segment about
+// to be opened, it happens when rotation switches to RotateNone, records
+// would go through the link to the previous segment otherwise.
+func (w *fWriter) releaseShortcut() {
+	name := logFilename(w.filename, w.rt, time.Now())
+	if !w.createShortcut || w.shortcut != name || w.realFilename == name {
+		return
+	}
+	fi, err := os.Lstat(name)
+	if err != nil {
+		return
+	}
+	if fi.Mode()&os.ModeSymlink == 0 {
+		// a hard link is only known while its segment is open
+		if w.file == nil {
+			return
+		}
+		cur, err := w.file.Stat()
+		if err != nil || !os.SameFile(fi, cur) {
+			return
+		}
+	}
+	if err := os.Remove(name); err != nil {
+		log.Printf("[filelog] remove shortcut %s fail %v\n", name, err)
+	}
+}
+
+// linkShortcut links name to target via a temp link and rename, so name
+// always exists during the switch.
+func linkShortcut(mode ShortcutMode, target, name string) error {
+	tmp := fmt.Sprintf("%s.%d.tmp", name, os.Getpid())
+	switch mode {
+	case ShortcutHardlink:
+		if fi, err := os.Lstat(name); err == nil {
+			if tf, err := os.Stat(target); err == nil && os.SameFile(fi, tf) {
+				return nil
+			}
+		}
+		os.Remove(tmp)
+		if err := os.Link(target, tmp); err != nil {
+			return err
+		}
+	default:
+		linkto := target
+		if mode == ShortcutSymlink {
+			rel, err := filepath.Rel(filepath.Dir(name), target)
+			if err != nil {
+				return err
+			}
+			linkto = rel
+		}
+		if cur, err := os.Readlink(name); err == nil && cur == linkto {
+			return nil
+		}
+		os.Remove(tmp)
+		if err := os.Symlink(linkto, tmp); err != nil {
+			return err
+		}
+	}
+	if err := os.Rename(tmp, name); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,49 @@
+package filelog
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSwitchShortcutToRotateNone(t *testing.T) {
+	for _, mode := range []ShortcutMode{ShortcutSymlink, ShortcutAbsSymlink, ShortcutHardlink} {
+		t.Run(mode.String(), func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "app.log")
+			w, err := NewWriter(path, RotateBy(RotateDaily), CreateShortcut(true), ShortcutLink(mode), DisableWatchFile())
+			if err != nil {
+				t.Fatal(err)
+			}
+			w.Write([]byte("daily\n"))
+			if err := w.SetOptions(RotateBy(RotateNone)); err != nil {
+				t.Fatal(err)
+			}
+			w.Write([]byte("none\n"))
+			if err := w.Close(); err != nil {
+				t.Fatal(err)
+			}
+			fi, err := os.Lstat(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !fi.Mode().IsRegular() {
+				t.Fatalf("%s is still a link", path)
+			}
+			expectContent(t, path, "none\n")
+			expectContent(t, logFilename(path, RotateDaily, time.Now()), "daily\n")
+		})
+	}
+}
+
+func expectContent(t *testing.T, path, content string) {
+	t.Helper()
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != content {
+		t.Fatalf("%s: got %q, expect %q", filepath.Base(path), data, content)
+	}
+}
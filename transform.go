@@ -0,0 +1,196 @@
+package filelog
+
+import (
+	"bytes"
+	"encoding/json"
+	"regexp"
+	"sync/atomic"
+)
+
+// Transformer rewrites or drops a record before it's written, transformers
+// run in the writing goroutine so producers are not slowed down
+type Transformer interface {
+	Name() string
+	// Transform returns the new record, keep false drops the record
+	Transform(record []byte) (out []byte, keep bool)
+}
+
+// Transforms apply ts in order to every record
+func Transforms(ts ...Transformer) OptionWrapper {
+	return func(o *Option) {
+		o.Transforms = append(o.Transforms, ts...)
+	}
+}
+
+// TransformStat counts records seen, modified and dropped by a transformer
+type TransformStat struct {
+	Name     string
+	Records  int64
+	Modified int64
+	Dropped  int64
+}
+
+type funcTransformer struct {
+	name string
+	fn   func([]byte) ([]byte, bool)
+}
+
+func (t funcTransformer) Name() string { return t.name }
+
+func (t funcTransformer) Transform(record []byte) ([]byte, bool) { return t.fn(record) }
+
+// TransformFunc create a named Transformer from fn
+func TransformFunc(name string, fn func(record []byte) ([]byte, bool)) Transformer {
+	return funcTransformer{name: name, fn: fn}
+}
+
+// DropMatching drop records matching re
+func DropMatching(name string, re *regexp.Regexp) Transformer {
+	return TransformFunc(name, func(record []byte) ([]byte, bool) {
+		return record, !re.Match(record)
+	})
+}
+
+// RegexRedactor replace matches of re with repl, repl supports $1 expansion
+func RegexRedactor(name string, re *regexp.Regexp, repl string) Transformer {
+	return TransformFunc(name, func(record []byte) ([]byte, bool) {
+		return re.ReplaceAll(record, []byte(repl)), true
+	})
+}
+
+var (
+	emailPattern = regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`)
+	cardPattern  = regexp.MustCompile(`\b(?:\d[ -]?){12,18}\d\b`)
+	tokenPattern = regexp.MustCompile(`(?i)\b(bearer|token|api[_-]?key|secret|password)(\s*[=:]\s*|\s+)([^\s"',;&]+)`)
+)
+
+// RedactEmails replace email addresses with ***
+func RedactEmails() Transformer {
+	return RegexRedactor("email", emailPattern, "***")
+}
+
+// RedactCardNumbers replace 13 to 19 digits card numbers passing the Luhn
+// check with ***, other numbers like timestamps and ids are kept
+func RedactCardNumbers() Transformer {
+	return TransformFunc("card", func(record []byte) ([]byte, bool) {
+		return cardPattern.ReplaceAllFunc(record, func(m []byte) []byte {
+			if luhnValid(m) {
+				return []byte("***")
+			}
+			return m
+		}), true
+	})
+}
+
+// luhnValid reports whether the digits of s pass the Luhn checksum,
+// separators are ignored.
+func luhnValid(s []byte) bool {
+	var sum, n int
+	for i := len(s) - 1; i >= 0; i-- {
+		c := s[i]
+		if c < '0' || c > '9' {
+			continue
+		}
+		d := int(c - '0')
+		if n%2 == 1 {
+			if d *= 2; d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		n++
+	}
+	return n > 0 && sum%10 == 0
+}
+
+// RedactTokens replace values of bearer, token, api_key, secret and password with ***
+func RedactTokens() Transformer {
+	return RegexRedactor("token", tokenPattern, "$1$2***")
+}
+
+// JSONFieldRedactor replace values of fields at any depth of JSON records
+// with repl, records which are not JSON objects are kept as is
+func JSONFieldRedactor(repl string, fields ...string) Transformer {
+	set := make(map[string]bool)
+	for _, f := range fields {
+		set[f] = true
+	}
+	return TransformFunc("json_field", func(record []byte) ([]byte, bool) {
+		trimmed := bytes.TrimRight(record, "\r\n")
+		if len(trimmed) == 0 || trimmed[0] != '{' {
+			return record, true
+		}
+		dec := json.NewDecoder(bytes.NewReader(trimmed))
+		dec.UseNumber()
+		var obj map[string]interface{}
+		if err := dec.Decode(&obj); err != nil {
+			return record, true
+		}
+		if !redactJSON(obj, set, repl) {
+			return record, true
+		}
+		out, err := json.Marshal(obj)
+		if err != nil {
+			return record, true
+		}
+		return append(out, record[len(trimmed):]...), true
+	})
+}
+
+func redactJSON(v interface{}, fields map[string]bool, repl string) (changed bool) {
+	switch val := v.(type) {
+	case map[string]interface{}:
+		for k, sub := range val {
+			if fields[k] {
+				val[k] = repl
+				changed = true
+			} else if redactJSON(sub, fields, repl) {
+				changed = true
+			}
+		}
+	case []interface{}:
+		for _, sub := range val {
+			if redactJSON(sub, fields, repl) {
+				changed = true
+			}
+		}
+	}
+	return
+}
+
+type transformStage struct {
+	t        Transformer
+	records  int64
+	modified int64
+	dropped  int64
+}
+
+// transform runs the chain, keep false means the record is dropped.
+func (w *fWriter) transform(p []byte) ([]byte, bool) {
+	for _, st := range w.transforms {
+		atomic.AddInt64(&st.records, 1)
+		out, keep := st.t.Transform(p)
+		if !keep {
+			atomic.AddInt64(&st.dropped, 1)
+			return nil, false
+		}
+		if !bytes.Equal(out, p) {
+			atomic.AddInt64(&st.modified, 1)
+		}
+		p = out
+	}
+	return p, true
+}
+
+func (w *fWriter) transformStats() []TransformStat {
+	stats := make([]TransformStat, 0, len(w.transforms))
+	for _, st := range w.transforms {
+		stats = append(stats, TransformStat{
+			Name:     st.t.Name(),
+			Records:  atomic.LoadInt64(&st.records),
+			Modified: atomic.LoadInt64(&st.modified),
+			Dropped:  atomic.LoadInt64(&st.dropped),
+		})
+	}
+	return stats
+}
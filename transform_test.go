@@ -0,0 +1,18 @@
+package filelog
+
+import "testing"
+
+func TestRedactCardNumbersRequiresLuhn(t *testing.T) {
+	redact := RedactCardNumbers()
+	for record, expect := range map[string]string{
+		`{"card":"4111111111111111"}`:     `{"card":"***"}`,
+		`card 4111 1111 1111 1111 paid`:   `card *** paid`,
+		`{"ts":1700000000000}`:            `{"ts":1700000000000}`,
+		`{"order_id":"4111111111111112"}`: `{"order_id":"4111111111111112"}`,
+	} {
+		got, keep := redact.Transform([]byte(record))
+		if !keep || string(got) != expect {
+			t.Fatalf("%s: got %s, expect %s", record, got, expect)
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package filelog
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// TruncateScope which segments Truncate empties
+type TruncateScope int
+
+const (
+	// TruncateCurrent empties the current segment only, which is the default
+	TruncateCurrent TruncateScope = iota
+	// TruncateAll empties the current and all previous segments, including archived ones
+	TruncateAll
+)
+
+// truncate empties segments of scope, it runs on the consumer goroutine.
+func (w *fWriter) truncate(scope TruncateScope) error {
+	var errs []error
+	if scope == TruncateAll {
+		for _, seg := range w.listSegments() {
+			if seg.current {
+				continue
+			}
+			if err := os.Truncate(seg.path, 0); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	if w.needRotate() {
+		if err := w.rotate(); err != nil {
+			return joinTruncateErrors(append(errs, err))
+		}
+	}
+	w.batchErr = nil
+	w.flushBatch()
+	if w.ring != nil {
+		if err := w.ring.reset(); err != nil {
+			errs = append(errs, err)
+		}
+		return joinTruncateErrors(errs)
+	}
+	if err := w.file.Truncate(0); err != nil {
+		return joinTruncateErrors(append(errs, err))
+	}
+	w.file.Seek(0, 0)
+	w.stats.reset(w.manifest != nil)
+	w.appendSegmentStart()
+	w.flushBatch()
+	if w.batchErr != nil {
+		errs = append(errs, w.batchErr)
+	}
+	return joinTruncateErrors(errs)
+}
+
+func joinTruncateErrors(errs []error) error {
+	switch len(errs) {
+	case 0:
+		return nil
+	case 1:
+		return errs[0]
+	}
+	msgs := make([]string, 0, len(errs))
+	for _, err := range errs {
+		msgs = append(msgs, err.Error())
+	}
+	return fmt.Errorf("truncate: %s", strings.Join(msgs, "; "))
+}
@@ -0,0 +1,223 @@
+package filelog
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Uploader ships a rotated segment, e.g. to object storage
+type Uploader interface {
+	Upload(ctx context.Context, path string) error
+}
+
+// UploadTo ship every rotated segment with u, segments are retried with
+// backoff until shipped and retention never removes unshipped segments
+func UploadTo(u Uploader) OptionWrapper {
+	return func(o *Option) {
+		o.Uploader = u
+	}
+}
+
+// DirUploader is an Uploader copying segments into a local directory
+type DirUploader struct {
+	Dir string
+}
+
+// Upload copy path into u.Dir
+func (u DirUploader) Upload(ctx context.Context, path string) error {
+	if err := os.MkdirAll(u.Dir, 0755); err != nil {
+		return err
+	}
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	dst := filepath.Join(u.Dir, filepath.Base(path))
+	out, err := os.OpenFile(dst+".tmp", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(out, in); err == nil {
+		err = out.Sync()
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(dst+".tmp", dst)
+	}
+	if err != nil {
+		os.Remove(dst + ".tmp")
+	}
+	return err
+}
+
+type shippedEntry struct {
+	Name string    `json:"name"`
+	Time time.Time `json:"time"`
+}
+
+// uploader runs uploads in its own goroutine and persists shipped segment
+// names in a manifest next to the log file.
+type uploader struct {
+	u        Uploader
+	manifest string
+	perm     fsPerm
+	segments *manifest
+	mu       sync.Mutex
+	shipped  map[string]bool
+	pending  []string
+	notify   chan struct{}
+	ctx      context.Context
+	cancel   context.CancelFunc
+	done     chan struct{}
+}
+
+func newUploader(u Uploader, filename string, segments *manifest, perm fsPerm) *uploader {
+	ctx, cancel := context.WithCancel(context.Background())
+	up := &uploader{
+		u:        u,
+		manifest: filename + ".uploaded",
+		perm:     perm,
+		segments: segments,
+		shipped:  make(map[string]bool),
+		notify:   make(chan struct{}, 1),
+		ctx:      ctx,
+		cancel:   cancel,
+		done:     make(chan struct{}),
+	}
+	up.load()
+	return up
+}
+
+func (up *uploader) load() {
+	fd, err := os.Open(up.manifest)
+	if err != nil {
+		return
+	}
+	defer fd.Close()
+	scanner := bufio.NewScanner(fd)
+	for scanner.Scan() {
+		var e shippedEntry
+		if json.Unmarshal(scanner.Bytes(), &e) == nil && e.Name != "" {
+			up.shipped[e.Name] = true
+		}
+	}
+}
+
+func (up *uploader) isShipped(path string) bool {
+	up.mu.Lock()
+	defer up.mu.Unlock()
+	return up.shipped[filepath.Base(path)]
+}
+
+func (up *uploader) markShipped(path string) {
+	name := filepath.Base(path)
+	up.mu.Lock()
+	up.shipped[name] = true
+	up.mu.Unlock()
+	line, _ := json.Marshal(shippedEntry{Name: name, Time: time.Now()})
+	fd, err := up.perm.openFile(up.manifest, os.O_WRONLY|os.O_APPEND)
+	if err != nil {
+		log.Printf("[filelog] write upload manifest %s fail %v\n", up.manifest, err)
+		return
+	}
+	defer fd.Close()
+	fd.Write(append(line, '\n'))
+	if up.segments != nil {
+		up.segments.markUploaded(path)
+	}
+}
+
+func (up *uploader) enqueue(path string) {
+	if path == "" || up.isShipped(path) {
+		return
+	}
+	up.mu.Lock()
+	up.pending = append(up.pending, path)
+	up.mu.Unlock()
+	select {
+	case up.notify <- struct{}{}:
+	default:
+	}
+}
+
+func (up *uploader) run() {
+	defer close(up.done)
+	for {
+		up.mu.Lock()
+		var path string
+		if len(up.pending) > 0 {
+			path = up.pending[0]
+			up.pending = up.pending[1:]
+		}
+		up.mu.Unlock()
+		if path == "" {
+			select {
+			case <-up.notify:
+				continue
+			case <-up.ctx.Done():
+				return
+			}
+		}
+		if !up.upload(path) {
+			return
+		}
+	}
+}
+
+// upload retries path until shipped, it returns false if closed meanwhile.
+func (up *uploader) upload(path string) bool {
+	backoff := time.Second
+	for {
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			return true
+		}
+		err := up.u.Upload(up.ctx, path)
+		if err == nil {
+			up.markShipped(path)
+			return true
+		}
+		log.Printf("[filelog] upload %s fail %v, retry in %v\n", path, err, backoff)
+		select {
+		case <-time.After(backoff):
+		case <-up.ctx.Done():
+			return false
+		}
+		if backoff *= 2; backoff > 5*time.Minute {
+			backoff = 5 * time.Minute
+		}
+	}
+}
+
+func (up *uploader) close() {
+	up.cancel()
+	<-up.done
+}
+
+// startUpload ships segments left unshipped by previous runs.
+func (w *fWriter) startUpload() {
+	if w.uploader == nil {
+		return
+	}
+	segs := w.listSegments()
+	for i := len(segs) - 1; i >= 0; i-- {
+		if !segs[i].current && segs[i].path != w.filename {
+			w.uploader.enqueue(segs[i].path)
+		}
+	}
+	go w.uploader.run()
+}
+
+// removable tells whether retention may delete the segment at path.
+func (w *fWriter) removable(path string) bool {
+	return w.uploader == nil || w.uploader.isShipped(path)
+}
@@ -27,7 +27,7 @@ func (w *fWriter) watchFile() {
 			case ev := <-iw.Event:
 				if ev.Mask == syscall.IN_DELETE {
 					abs, _ := filepath.Abs(ev.Name)
-					if abs == w.realFilename || abs == w.filename {
+					if abs == w.realFilename || abs == w.filename || abs == w.shortcut {
 						atomic.StoreInt32(&w.reOpen, 1)
 					}
 				}